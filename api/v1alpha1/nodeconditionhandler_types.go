@@ -1,30 +1,202 @@
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-// EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
-// NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
+// Action is what the controller does with a node whose condition has a given reason.
+// +kubebuilder:validation:Enum=None;Cordon;Drain;Taint;UncordonOnClear
+type Action string
+
+const (
+	// ActionNone leaves the node alone. Scheduled events are still marked drained, so the
+	// nodify daemon may acknowledge them.
+	ActionNone Action = "None"
+	// ActionCordon marks the node unschedulable.
+	ActionCordon Action = "Cordon"
+	// ActionDrain cordons the node and evicts its pods.
+	ActionDrain Action = "Drain"
+	// ActionTaint applies the handler's Taint to the node.
+	ActionTaint Action = "Taint"
+	// ActionUncordonOnClear marks the node schedulable again once the condition clears, unless
+	// another handler still wants it cordoned.
+	ActionUncordonOnClear Action = "UncordonOnClear"
+)
+
+// ReasonAction selects the Action for a reason of the handled condition.
+type ReasonAction struct {
+	// Reason of the condition, e.g. Reboot for the MaintenanceScheduled condition.
+	// +kubebuilder:validation:MinLength=1
+	Reason string `json:"reason"`
+	// Action taken while the condition has Reason.
+	Action Action `json:"action"`
+}
+
+// DrainOptions configures how the Drain action evicts the pods of a node, like the flags of
+// kubectl drain. Options left out keep their defaults.
+type DrainOptions struct {
+	// Force also deletes pods not managed by a controller, which are not recreated elsewhere.
+	// Without it, draining a node running such pods fails. Defaults to true.
+	// +optional
+	Force *bool `json:"force,omitempty"`
+
+	// IgnoreAllDaemonSets leaves pods managed by a DaemonSet alone. Without it, draining a node
+	// running such pods fails. Defaults to true.
+	// +optional
+	IgnoreAllDaemonSets *bool `json:"ignoreAllDaemonSets,omitempty"`
+
+	// DeleteEmptyDirData also deletes pods using emptyDir volumes, whose data is lost. Without it,
+	// draining a node running such pods fails. Defaults to true.
+	// +optional
+	DeleteEmptyDirData *bool `json:"deleteEmptyDirData,omitempty"`
+
+	// GracePeriodSeconds overrides the termination grace period of evicted pods. Defaults to -1,
+	// which uses the grace period of each pod.
+	// +optional
+	GracePeriodSeconds *int `json:"gracePeriodSeconds,omitempty"`
+
+	// TimeoutSeconds is how long to wait for the pods to be evicted before the drain fails.
+	// Defaults to 60.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	TimeoutSeconds *int `json:"timeoutSeconds,omitempty"`
+
+	// PodSelector only evicts the pods it selects.
+	// +optional
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+
+	// SkipWaitForDeleteTimeoutSeconds stops waiting for pods that have been terminating for
+	// longer, e.g. because the node is not ready. Zero always waits.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	SkipWaitForDeleteTimeoutSeconds int `json:"skipWaitForDeleteTimeoutSeconds,omitempty"`
+
+	// DisableEviction deletes pods instead of evicting them, which bypasses PodDisruptionBudgets.
+	// +optional
+	DisableEviction bool `json:"disableEviction,omitempty"`
+}
 
 // NodeConditionHandlerSpec defines the desired state of NodeConditionHandler
 type NodeConditionHandlerSpec struct {
-	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// ConditionType is the type of the node condition handled, e.g. MaintenanceScheduled. If the
+	// nodify daemon reports a condition per EventType instead, MaintenanceScheduled is derived from
+	// the most disruptive of them.
+	// +kubebuilder:validation:MinLength=1
+	ConditionType string `json:"conditionType"`
 
-	// Foo is an example field of NodeConditionHandler. Edit nodeconditionhandler_types.go to remove/update
-	Foo string `json:"foo,omitempty"`
+	// NodeSelector selects the nodes handled. An empty selector selects every node.
+	// +optional
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+
+	// Reasons are the actions taken for each reason of the condition. Reasons that are not
+	// listed are left alone.
+	// +kubebuilder:validation:MinItems=1
+	Reasons []ReasonAction `json:"reasons"`
+
+	// Taint is applied by the Taint action, and removed once the reason of the condition maps to
+	// another action or none. It defaults to a NoSchedule taint keyed by the condition type with the
+	// reason as value, e.g. nodify.azure.microsoft.com/MaintenanceScheduled=Reboot:NoSchedule.
+	// +optional
+	Taint *corev1.Taint `json:"taint,omitempty"`
+
+	// Drain configures the Drain action.
+	// +optional
+	Drain *DrainOptions `json:"drain,omitempty"`
+}
+
+// MaintenancePhase is how far a node has come through the maintenance a handler reacts to.
+// +kubebuilder:validation:Enum=Pending;Cordoned;Draining;Drained;AwaitingRecovery;Uncordoned;Failed
+type MaintenancePhase string
+
+const (
+	// PhasePending means the condition requires an action that has not completed yet.
+	PhasePending MaintenancePhase = "Pending"
+	// PhaseCordoned means the node is cordoned.
+	PhaseCordoned MaintenancePhase = "Cordoned"
+	// PhaseDraining means pods are being evicted from the node.
+	PhaseDraining MaintenancePhase = "Draining"
+	// PhaseDrained means the node is drained and ready for the maintenance.
+	PhaseDrained MaintenancePhase = "Drained"
+	// PhaseAwaitingRecovery means the maintenance started or the node is not ready, and the
+	// condition has not cleared yet.
+	PhaseAwaitingRecovery MaintenancePhase = "AwaitingRecovery"
+	// PhaseUncordoned means the condition cleared and the node is schedulable again, or was left
+	// cordoned by someone else.
+	PhaseUncordoned MaintenancePhase = "Uncordoned"
+	// PhaseFailed means the last attempt to drain the node failed.
+	PhaseFailed MaintenancePhase = "Failed"
+)
+
+// NodeMaintenanceStatus is the progress of a node through maintenance.
+type NodeMaintenanceStatus struct {
+	// Name of the node.
+	Name string `json:"name"`
+	// Phase of the maintenance.
+	Phase MaintenancePhase `json:"phase"`
+	// Reason of the handled condition.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// EventIDs are the scheduled events published on the node by the nodify daemon.
+	// +optional
+	EventIDs []string `json:"eventIds,omitempty"`
+	// NotBefore is the earliest time one of the scheduled events may start.
+	// +optional
+	NotBefore *metav1.Time `json:"notBefore,omitempty"`
+	// DrainStartTime is when the last drain started.
+	// +optional
+	DrainStartTime *metav1.Time `json:"drainStartTime,omitempty"`
+	// DrainCompletionTime is when the last drain completed.
+	// +optional
+	DrainCompletionTime *metav1.Time `json:"drainCompletionTime,omitempty"`
+	// PodsRemaining is the number of pods left to evict.
+	// +optional
+	PodsRemaining int `json:"podsRemaining,omitempty"`
+	// Message explains the phase, e.g. why the drain failed.
+	// +optional
+	Message string `json:"message,omitempty"`
+	// LastTransitionTime is when the phase last changed.
+	LastTransitionTime metav1.Time `json:"lastTransitionTime"`
 }
 
 // NodeConditionHandlerStatus defines the observed state of NodeConditionHandler
 type NodeConditionHandlerStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// ObservedGeneration is the generation of the spec the status reflects.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Nodes are the nodes under maintenance, and the nodes that recently completed it.
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	Nodes []NodeMaintenanceStatus `json:"nodes,omitempty"`
+
+	// NodesUnderMaintenance is the number of Nodes that have not been uncordoned yet.
+	NodesUnderMaintenance int `json:"nodesUnderMaintenance"`
+
+	// NodesDraining is the number of Nodes being drained.
+	NodesDraining int `json:"nodesDraining"`
+
+	// NodesFailed is the number of Nodes whose drain failed.
+	NodesFailed int `json:"nodesFailed"`
+
+	// Conditions are Ready, which is false if the spec is invalid, Progressing, which is true
+	// while nodes are under maintenance, and Degraded, which is true while drains are failing.
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
 //+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Condition",type=string,JSONPath=`.spec.conditionType`
+//+kubebuilder:printcolumn:name="Maintenance",type=integer,JSONPath=`.status.nodesUnderMaintenance`
+//+kubebuilder:printcolumn:name="Draining",type=integer,JSONPath=`.status.nodesDraining`
+//+kubebuilder:printcolumn:name="Failed",type=integer,JSONPath=`.status.nodesFailed`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // NodeConditionHandler is the Schema for the nodeconditionhandlers API
 type NodeConditionHandler struct {
@@ -5,16 +5,63 @@
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DrainOptions) DeepCopyInto(out *DrainOptions) {
+	*out = *in
+	if in.Force != nil {
+		in, out := &in.Force, &out.Force
+		*out = new(bool)
+		**out = **in
+	}
+	if in.IgnoreAllDaemonSets != nil {
+		in, out := &in.IgnoreAllDaemonSets, &out.IgnoreAllDaemonSets
+		*out = new(bool)
+		**out = **in
+	}
+	if in.DeleteEmptyDirData != nil {
+		in, out := &in.DeleteEmptyDirData, &out.DeleteEmptyDirData
+		*out = new(bool)
+		**out = **in
+	}
+	if in.GracePeriodSeconds != nil {
+		in, out := &in.GracePeriodSeconds, &out.GracePeriodSeconds
+		*out = new(int)
+		**out = **in
+	}
+	if in.TimeoutSeconds != nil {
+		in, out := &in.TimeoutSeconds, &out.TimeoutSeconds
+		*out = new(int)
+		**out = **in
+	}
+	if in.PodSelector != nil {
+		in, out := &in.PodSelector, &out.PodSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DrainOptions.
+func (in *DrainOptions) DeepCopy() *DrainOptions {
+	if in == nil {
+		return nil
+	}
+	out := new(DrainOptions)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NodeConditionHandler) DeepCopyInto(out *NodeConditionHandler) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
-	out.Status = in.Status
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeConditionHandler.
@@ -70,6 +117,26 @@ func (in *NodeConditionHandlerList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NodeConditionHandlerSpec) DeepCopyInto(out *NodeConditionHandlerSpec) {
 	*out = *in
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Reasons != nil {
+		in, out := &in.Reasons, &out.Reasons
+		*out = make([]ReasonAction, len(*in))
+		copy(*out, *in)
+	}
+	if in.Taint != nil {
+		in, out := &in.Taint, &out.Taint
+		*out = new(corev1.Taint)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Drain != nil {
+		in, out := &in.Drain, &out.Drain
+		*out = new(DrainOptions)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeConditionHandlerSpec.
@@ -85,6 +152,20 @@ func (in *NodeConditionHandlerSpec) DeepCopy() *NodeConditionHandlerSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NodeConditionHandlerStatus) DeepCopyInto(out *NodeConditionHandlerStatus) {
 	*out = *in
+	if in.Nodes != nil {
+		in, out := &in.Nodes, &out.Nodes
+		*out = make([]NodeMaintenanceStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeConditionHandlerStatus.
@@ -96,3 +177,51 @@ func (in *NodeConditionHandlerStatus) DeepCopy() *NodeConditionHandlerStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeMaintenanceStatus) DeepCopyInto(out *NodeMaintenanceStatus) {
+	*out = *in
+	if in.EventIDs != nil {
+		in, out := &in.EventIDs, &out.EventIDs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.NotBefore != nil {
+		in, out := &in.NotBefore, &out.NotBefore
+		*out = (*in).DeepCopy()
+	}
+	if in.DrainStartTime != nil {
+		in, out := &in.DrainStartTime, &out.DrainStartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.DrainCompletionTime != nil {
+		in, out := &in.DrainCompletionTime, &out.DrainCompletionTime
+		*out = (*in).DeepCopy()
+	}
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeMaintenanceStatus.
+func (in *NodeMaintenanceStatus) DeepCopy() *NodeMaintenanceStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeMaintenanceStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReasonAction) DeepCopyInto(out *ReasonAction) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReasonAction.
+func (in *ReasonAction) DeepCopy() *ReasonAction {
+	if in == nil {
+		return nil
+	}
+	out := new(ReasonAction)
+	in.DeepCopyInto(out)
+	return out
+}
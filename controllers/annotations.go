@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// ScheduledEventsAnnotation is set by the nodify daemon to the comma separated EventIds
+	// of the maintenance events scheduled for the node.
+	ScheduledEventsAnnotation = "nodify.azure.microsoft.com/scheduled-events"
+	// DrainedEventsAnnotation is set by the controller to the comma separated EventIds it has
+	// finished preparing the node for. The daemon only acknowledges events listed here.
+	DrainedEventsAnnotation = "nodify.azure.microsoft.com/drained-events"
+	// EventAnnotationPrefix is followed by an EventId in the key of the annotation the nodify
+	// daemon sets to the ScheduledEvent for that event.
+	EventAnnotationPrefix = "events.nodify.azure.microsoft.com/"
+)
+
+// ScheduledEvent is the machine readable form of a scheduled event published by the nodify daemon.
+type ScheduledEvent struct {
+	EventID           string     `json:"eventId"`
+	EventType         string     `json:"eventType"`
+	EventStatus       string     `json:"eventStatus,omitempty"`
+	EventSource       string     `json:"eventSource,omitempty"`
+	ResourceType      string     `json:"resourceType,omitempty"`
+	Resources         []string   `json:"resources,omitempty"`
+	NotBefore         *time.Time `json:"notBefore,omitempty"`
+	DurationInSeconds int        `json:"durationInSeconds,omitempty"`
+	Description       string     `json:"description,omitempty"`
+}
+
+// scheduledEvents returns the ScheduledEvents published on the node, ordered by EventId. Events
+// listed by ScheduledEventsAnnotation whose details are missing or invalid only have an EventID.
+func scheduledEvents(node *corev1.Node) []ScheduledEvent {
+	ids := splitEventIDs(node.Annotations[ScheduledEventsAnnotation])
+	sort.Strings(ids)
+	events := make([]ScheduledEvent, 0, len(ids))
+	for _, id := range ids {
+		event := ScheduledEvent{}
+		if err := json.Unmarshal([]byte(node.Annotations[EventAnnotationPrefix+id]), &event); err != nil {
+			event = ScheduledEvent{}
+		}
+		event.EventID = id
+		events = append(events, event)
+	}
+	return events
+}
+
+// drained reports whether the node has already been prepared for every scheduled event.
+func drained(node *corev1.Node) bool {
+	scheduled := splitEventIDs(node.Annotations[ScheduledEventsAnnotation])
+	if len(scheduled) == 0 {
+		return false
+	}
+	done := map[string]bool{}
+	for _, id := range splitEventIDs(node.Annotations[DrainedEventsAnnotation]) {
+		done[id] = true
+	}
+	for _, id := range scheduled {
+		if !done[id] {
+			return false
+		}
+	}
+	return true
+}
+
+// markDrained records that the node is ready for the events scheduled for it, which lets
+// the daemon acknowledge them.
+func (r *NodeConditionHandlerReconciler) markDrained(ctx context.Context, node *corev1.Node) error {
+	scheduled := node.Annotations[ScheduledEventsAnnotation]
+	if scheduled == "" || node.Annotations[DrainedEventsAnnotation] == scheduled {
+		return nil
+	}
+	patch := client.MergeFrom(node.DeepCopy())
+	node.Annotations[DrainedEventsAnnotation] = scheduled
+	return r.Patch(ctx, node, patch)
+}
+
+// clearDrained forgets about completed drains once no more events are scheduled.
+func (r *NodeConditionHandlerReconciler) clearDrained(ctx context.Context, node *corev1.Node) error {
+	if _, ok := node.Annotations[DrainedEventsAnnotation]; !ok {
+		return nil
+	}
+	patch := client.MergeFrom(node.DeepCopy())
+	delete(node.Annotations, DrainedEventsAnnotation)
+	return r.Patch(ctx, node, patch)
+}
+
+func splitEventIDs(value string) []string {
+	var ids []string
+	for _, id := range strings.Split(value, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
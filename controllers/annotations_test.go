@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDrained(t *testing.T) {
+	tests := map[string]struct {
+		annotations map[string]string
+		want        bool
+	}{
+		"no annotations": {},
+		"nothing scheduled": {
+			annotations: map[string]string{DrainedEventsAnnotation: "a"},
+		},
+		"not drained": {
+			annotations: map[string]string{ScheduledEventsAnnotation: "a"},
+		},
+		"drained": {
+			annotations: map[string]string{ScheduledEventsAnnotation: "a,b", DrainedEventsAnnotation: "a,b"},
+			want:        true,
+		},
+		"new event scheduled after drain": {
+			annotations: map[string]string{ScheduledEventsAnnotation: "a,c", DrainedEventsAnnotation: "a,b"},
+		},
+	}
+	for name, tt := range tests {
+		node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations}}
+		if got := drained(node); got != tt.want {
+			t.Errorf("%s: drained() = %v, want %v", name, got, tt.want)
+		}
+	}
+}
+
+func TestScheduledEvents(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		ScheduledEventsAnnotation:   "b,a",
+		EventAnnotationPrefix + "a": `{"eventId":"a","eventType":"Reboot","eventStatus":"Scheduled","notBefore":"2021-03-30T13:39:24Z"}`,
+		EventAnnotationPrefix + "b": `not json`,
+	}}}
+	events := scheduledEvents(node)
+	if len(events) != 2 || events[0].EventID != "a" || events[1].EventID != "b" {
+		t.Fatalf("scheduledEvents() = %+v, want a and b", events)
+	}
+	want := time.Date(2021, 3, 30, 13, 39, 24, 0, time.UTC)
+	if events[0].EventType != "Reboot" || events[0].NotBefore == nil || !events[0].NotBefore.Equal(want) {
+		t.Errorf("scheduledEvents()[0] = %+v, want a Reboot NotBefore %v", events[0], want)
+	}
+	if events[1].EventType != "" {
+		t.Errorf("scheduledEvents()[1] = %+v, want only the EventId of invalid details", events[1])
+	}
+}
@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	azurev1alpha1 "github.com/juan-lee/nodify/api/v1alpha1"
+)
+
+// CordonedAnnotation is set by the controller on the nodes it cordoned, to the comma separated
+// EventIds it cordoned the node for, or the names of the handlers that did if no events are
+// scheduled. The controller only uncordons nodes with this annotation, so cordons placed by
+// people or other tools are left alone.
+const CordonedAnnotation = "nodify.azure.microsoft.com/cordoned-for"
+
+// cordonedFor returns the value of CordonedAnnotation when the plan cordons the node.
+func (p *plan) cordonedFor(node *corev1.Node) string {
+	if ids := splitEventIDs(node.Annotations[ScheduledEventsAnnotation]); len(ids) > 0 {
+		return strings.Join(ids, ",")
+	}
+	var handlers []string
+	for _, a := range p.actions {
+		if a.Action == azurev1alpha1.ActionCordon || a.Action == azurev1alpha1.ActionDrain {
+			handlers = append(handlers, a.Handler)
+		}
+	}
+	return strings.Join(handlers, ",")
+}
+
+// ownsCordon reports whether the controller cordoned the node.
+func ownsCordon(node *corev1.Node) bool {
+	_, ok := node.Annotations[CordonedAnnotation]
+	return ok
+}
+
+// cordon marks the node unschedulable and records that the controller did so for owner. A node
+// that is already cordoned is left alone, and remains owned by whoever cordoned it.
+func (r *NodeConditionHandlerReconciler) cordon(ctx context.Context, node *corev1.Node, owner string) error {
+	log := r.Log.WithValues("node", node.Name)
+	if node.Spec.Unschedulable {
+		if !ownsCordon(node) {
+			log.Info("Node already cordoned by someone else, it won't be uncordoned")
+		}
+		return nil
+	}
+	log.Info("Cordoning node", "for", owner)
+	patch := client.MergeFrom(node.DeepCopy())
+	node.Spec.Unschedulable = true
+	if node.Annotations == nil {
+		node.Annotations = map[string]string{}
+	}
+	node.Annotations[CordonedAnnotation] = owner
+	return r.Patch(ctx, node, patch)
+}
+
+// uncordon marks the node schedulable if the controller cordoned it. A cordon placed by someone
+// else is left alone, and an event on the node says so the first time.
+func (r *NodeConditionHandlerReconciler) uncordon(ctx context.Context, node *corev1.Node) error {
+	log := r.Log.WithValues("node", node.Name)
+	owned := ownsCordon(node)
+	if node.Spec.Unschedulable && !owned {
+		if _, reported := r.leftAlone.LoadOrStore(node.Name, true); !reported {
+			log.Info("Not uncordoning node, it was cordoned by someone else")
+			r.Recorder.Event(node, corev1.EventTypeNormal, "CordonLeftAlone",
+				"Not uncordoning the node after maintenance because nodify did not cordon it.")
+		}
+		return nil
+	}
+	r.leftAlone.Delete(node.Name)
+	if !owned {
+		return nil
+	}
+	patch := client.MergeFrom(node.DeepCopy())
+	if node.Spec.Unschedulable {
+		log.Info("Uncordoning node", "cordonedFor", node.Annotations[CordonedAnnotation])
+		node.Spec.Unschedulable = false
+	}
+	// The annotation is also dropped if someone else uncordoned the node meanwhile.
+	delete(node.Annotations, CordonedAnnotation)
+	return r.Patch(ctx, node, patch)
+}
@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func newTestReconciler(node *corev1.Node) (*NodeConditionHandlerReconciler, *record.FakeRecorder) {
+	recorder := record.NewFakeRecorder(10)
+	return &NodeConditionHandlerReconciler{
+		Client:   fake.NewClientBuilder().WithObjects(node).Build(),
+		Log:      log.NullLogger{},
+		Recorder: recorder,
+	}, recorder
+}
+
+func TestCordonOwnership(t *testing.T) {
+	ctx := context.Background()
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node"}}
+	r, recorder := newTestReconciler(node)
+
+	if err := r.cordon(ctx, node, "event-a"); err != nil {
+		t.Fatal(err)
+	}
+	got := &corev1.Node{}
+	if err := r.Get(ctx, client.ObjectKey{Name: "node"}, got); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Spec.Unschedulable || got.Annotations[CordonedAnnotation] != "event-a" {
+		t.Fatalf("cordon() = %+v, want the node cordoned for event-a", got)
+	}
+
+	if err := r.uncordon(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(ctx, client.ObjectKey{Name: "node"}, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Spec.Unschedulable || ownsCordon(got) {
+		t.Errorf("uncordon() = %+v, want the node schedulable without the annotation", got)
+	}
+	if len(recorder.Events) != 0 {
+		t.Errorf("uncordon() recorded %q, want no events", <-recorder.Events)
+	}
+}
+
+func TestForeignCordonLeftAlone(t *testing.T) {
+	ctx := context.Background()
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node"}, Spec: corev1.NodeSpec{Unschedulable: true}}
+	r, recorder := newTestReconciler(node)
+
+	if err := r.cordon(ctx, node, "event-a"); err != nil {
+		t.Fatal(err)
+	}
+	if ownsCordon(node) {
+		t.Fatalf("cordon() claimed a cordon placed by someone else: %+v", node)
+	}
+	for n := 0; n < 2; n++ {
+		if err := r.uncordon(ctx, node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := &corev1.Node{}
+	if err := r.Get(ctx, client.ObjectKey{Name: "node"}, got); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Spec.Unschedulable {
+		t.Errorf("uncordon() uncordoned a node cordoned by someone else")
+	}
+	if len(recorder.Events) != 1 {
+		t.Errorf("recorded %d events, want one CordonLeftAlone event", len(recorder.Events))
+	}
+}
@@ -0,0 +1,227 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kctldrain "k8s.io/kubectl/pkg/drain"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	azurev1alpha1 "github.com/juan-lee/nodify/api/v1alpha1"
+)
+
+// DrainAnnotation is set by the controller to the progress of the drain of the node. A drain
+// interrupted by a restart or failover of the controller resumes from it.
+const DrainAnnotation = "nodify.azure.microsoft.com/drain"
+
+const (
+	// drainPollInterval is how often the controller checks on a running drain.
+	drainPollInterval = 10 * time.Second
+	// drainBackoff is how long the controller waits to retry a drain that failed once. The wait
+	// doubles with every attempt, up to maxDrainBackoff.
+	drainBackoff    = 10 * time.Second
+	maxDrainBackoff = 5 * time.Minute
+)
+
+// drainState is the checkpoint of a drain recorded in DrainAnnotation. It is kept once the drain
+// completed, until the node no longer needs to be drained, so a node is drained once for whom it
+// is drained for even if no scheduled events mark it drained.
+type drainState struct {
+	// For is whom the node is drained for, as in CordonedAnnotation. The drain starts over if
+	// it changes.
+	For       string      `json:"for"`
+	StartTime metav1.Time `json:"startTime"`
+	Attempts  int         `json:"attempts"`
+	// LastError is the error of the last attempt that failed.
+	LastError string `json:"lastError,omitempty"`
+	// RetryAfter is set while the controller waits to retry a drain that failed.
+	RetryAfter    *metav1.Time `json:"retryAfter,omitempty"`
+	PodsRemaining int          `json:"podsRemaining"`
+	// Completed is set once the drain succeeded.
+	Completed *metav1.Time `json:"completed,omitempty"`
+}
+
+// drainStateOf returns the checkpoint of the drain of the node, or nil if none is recorded.
+func drainStateOf(node *corev1.Node) *drainState {
+	value, ok := node.Annotations[DrainAnnotation]
+	if !ok {
+		return nil
+	}
+	state := &drainState{}
+	if err := json.Unmarshal([]byte(value), state); err != nil {
+		return nil
+	}
+	return state
+}
+
+func (s *drainState) progress() *drainProgress {
+	progress := &drainProgress{started: &s.StartTime, completed: s.Completed, podsRemaining: s.PodsRemaining}
+	if s.RetryAfter != nil {
+		progress.err = errors.New(s.LastError)
+	}
+	return progress
+}
+
+// backoff returns how long to wait before retrying the drain after its last attempt failed.
+func (s *drainState) backoff() time.Duration {
+	backoff := drainBackoff
+	for n := 1; n < s.Attempts && backoff < maxDrainBackoff; n++ {
+		backoff *= 2
+	}
+	if backoff > maxDrainBackoff {
+		backoff = maxDrainBackoff
+	}
+	return backoff
+}
+
+// drainOp is a drain running in the background.
+type drainOp struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// finished reports whether the drain is done, and its error if so.
+func (o *drainOp) finished() (bool, error) {
+	select {
+	case <-o.done:
+		return true, o.err
+	default:
+		return false, nil
+	}
+}
+
+// drain cordons and drains the node for owner. The drain runs in the background, so the node is
+// requeued until it is done, and failed drains are retried with a backoff. Invalid drain options
+// are reported and not retried, since every node is reconciled again once the handler is fixed.
+func (r *NodeConditionHandlerReconciler) drain(ctx context.Context, node *corev1.Node, owner string,
+	opts *azurev1alpha1.DrainOptions) (ctrl.Result, *drainProgress, error) {
+	log := r.Log.WithValues("node", node.Name)
+	state := drainStateOf(node)
+	if state == nil || state.For != owner {
+		state = &drainState{For: owner, StartTime: metav1.Now()}
+	}
+	helper := newDrainHelper(r.Clientset, log)
+	if err := withDrainOptions(helper, opts); err != nil {
+		log.Error(err, "Invalid drain options")
+		progress := state.progress()
+		progress.err = err
+		return ctrl.Result{}, progress, nil
+	}
+
+	if v, ok := r.drains.Load(node.Name); ok {
+		return r.checkDrain(ctx, node, state, v.(*drainOp), helper)
+	}
+	if state.RetryAfter != nil && time.Now().Before(state.RetryAfter.Time) {
+		return ctrl.Result{RequeueAfter: time.Until(state.RetryAfter.Time)}, state.progress(), nil
+	}
+	if err := r.cordon(ctx, node, owner); err != nil {
+		return ctrl.Result{}, nil, err
+	}
+	if state.Attempts > 0 {
+		log.Info("Resuming drain", "attempts", state.Attempts, "lastError", state.LastError)
+	}
+	state.Attempts++
+	state.RetryAfter = nil
+	state.PodsRemaining = podsRemaining(helper, node.Name)
+	if err := r.checkpoint(ctx, node, state); err != nil {
+		return ctrl.Result{}, nil, err
+	}
+	r.startDrain(helper, node.Name, log)
+	return ctrl.Result{RequeueAfter: drainPollInterval}, state.progress(), nil
+}
+
+// checkDrain checkpoints the progress of the drain running for the node, and completes it once
+// it is done.
+func (r *NodeConditionHandlerReconciler) checkDrain(ctx context.Context, node *corev1.Node, state *drainState,
+	op *drainOp, helper *kctldrain.Helper) (ctrl.Result, *drainProgress, error) {
+	log := r.Log.WithValues("node", node.Name)
+	done, err := op.finished()
+	if !done {
+		state.PodsRemaining = podsRemaining(helper, node.Name)
+		return ctrl.Result{RequeueAfter: drainPollInterval}, state.progress(), r.checkpoint(ctx, node, state)
+	}
+	r.drains.Delete(node.Name)
+	if err != nil {
+		log.Info("Errors draining node", "err", err, "attempts", state.Attempts)
+		retryAfter := metav1.NewTime(time.Now().Add(state.backoff()))
+		state.LastError, state.RetryAfter = err.Error(), &retryAfter
+		state.PodsRemaining = podsRemaining(helper, node.Name)
+		return ctrl.Result{RequeueAfter: state.backoff()}, state.progress(), r.checkpoint(ctx, node, state)
+	}
+	log.Info("Drained node", "attempts", state.Attempts)
+	completed := metav1.Now()
+	state.Completed, state.LastError, state.RetryAfter, state.PodsRemaining = &completed, "", nil, 0
+	if err := r.checkpoint(ctx, node, state); err != nil {
+		return ctrl.Result{}, nil, err
+	}
+	return ctrl.Result{}, state.progress(), r.markDrained(ctx, node)
+}
+
+// startDrain drains the node called name in the background.
+func (r *NodeConditionHandlerReconciler) startDrain(helper *kctldrain.Helper, name string, log logr.Logger) {
+	ctx, cancel := context.WithCancel(context.Background())
+	helper.Ctx = ctx
+	op := &drainOp{cancel: cancel, done: make(chan struct{})}
+	r.drains.Store(name, op)
+	go func() {
+		defer close(op.done)
+		log.Info("Draining node")
+		op.err = kctldrain.RunNodeDrain(helper, name)
+	}()
+}
+
+// stopDrain cancels the drain of the node and forgets its progress, once it no longer needs to
+// be drained.
+func (r *NodeConditionHandlerReconciler) stopDrain(ctx context.Context, node *corev1.Node) error {
+	r.cancelDrain(node.Name)
+	return r.checkpoint(ctx, node, nil)
+}
+
+// cancelDrain cancels the drain running for the node called name, if any.
+func (r *NodeConditionHandlerReconciler) cancelDrain(name string) {
+	if v, ok := r.drains.LoadAndDelete(name); ok {
+		r.Log.Info("Canceling drain", "node", name)
+		v.(*drainOp).cancel()
+	}
+}
+
+// checkpoint records state in DrainAnnotation, or removes the annotation if state is nil.
+func (r *NodeConditionHandlerReconciler) checkpoint(ctx context.Context, node *corev1.Node, state *drainState) error {
+	value, ok := node.Annotations[DrainAnnotation]
+	if state == nil && !ok {
+		return nil
+	}
+	patch := client.MergeFrom(node.DeepCopy())
+	if state == nil {
+		delete(node.Annotations, DrainAnnotation)
+		return r.Patch(ctx, node, patch)
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if ok && value == string(data) {
+		return nil
+	}
+	if node.Annotations == nil {
+		node.Annotations = map[string]string{}
+	}
+	node.Annotations[DrainAnnotation] = string(data)
+	return r.Patch(ctx, node, patch)
+}
+
+// podsRemaining returns the number of pods the drain still has to delete from the node.
+func podsRemaining(helper *kctldrain.Helper, name string) int {
+	pods, _ := helper.GetPodsForDeletion(name)
+	if pods == nil {
+		return 0
+	}
+	return len(pods.Pods())
+}
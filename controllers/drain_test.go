@@ -0,0 +1,257 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	azurev1alpha1 "github.com/juan-lee/nodify/api/v1alpha1"
+)
+
+func newDrainTestReconciler(t *testing.T, state *drainState, pods ...corev1.Pod) (*NodeConditionHandlerReconciler, *corev1.Node) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node", Annotations: map[string]string{
+		ScheduledEventsAnnotation: "a",
+		DrainAnnotation:           string(data),
+	}}}
+	clientset := kubefake.NewSimpleClientset()
+	for n := range pods {
+		if _, err := clientset.CoreV1().Pods(pods[n].Namespace).Create(context.Background(), &pods[n], metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &NodeConditionHandlerReconciler{
+		Client:    fake.NewClientBuilder().WithObjects(node).Build(),
+		Clientset: clientset,
+		Log:       log.NullLogger{},
+	}, node
+}
+
+func TestDrainResumes(t *testing.T) {
+	ctx := context.Background()
+	started := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+	retryAfter := metav1.NewTime(time.Now().Add(-time.Minute))
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod"},
+		Spec:       corev1.PodSpec{NodeName: "node"},
+	}
+	r, node := newDrainTestReconciler(t, &drainState{
+		For: "a", StartTime: started, Attempts: 1, LastError: "timed out", RetryAfter: &retryAfter,
+	}, pod)
+
+	result, progress, err := r.drain(ctx, node, "a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RequeueAfter != drainPollInterval || progress.err != nil || !progress.started.Equal(&started) || progress.podsRemaining != 1 {
+		t.Fatalf("drain() = %+v, %+v, want the drain resumed and requeued", result, progress)
+	}
+	state := drainStateOf(node)
+	if state == nil || state.Attempts != 2 || state.RetryAfter != nil || !node.Spec.Unschedulable {
+		t.Fatalf("drain() checkpointed %+v on %+v, want a second attempt on a cordoned node", state, node)
+	}
+	v, ok := r.drains.Load(node.Name)
+	if !ok {
+		t.Fatal("drain() did not start draining the node")
+	}
+	<-v.(*drainOp).done
+
+	result, progress, err = r.drain(ctx, node, "a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RequeueAfter != 0 || progress.err != nil || progress.completed == nil {
+		t.Fatalf("drain() = %+v, %+v, want the drain completed", result, progress)
+	}
+	got := &corev1.Node{}
+	if err := r.Get(ctx, client.ObjectKey{Name: node.Name}, got); err != nil {
+		t.Fatal(err)
+	}
+	if state := drainStateOf(got); state == nil || state.Completed == nil || !drained(got) {
+		t.Errorf("drain() left %+v, want the node drained with a completed checkpoint", got.Annotations)
+	}
+	pods, err := r.Clientset.CoreV1().Pods("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods.Items) != 0 {
+		t.Errorf("drain() left %d pods on the node", len(pods.Items))
+	}
+}
+
+func TestDrainBackoff(t *testing.T) {
+	retryAfter := metav1.NewTime(time.Now().Add(time.Minute))
+	r, node := newDrainTestReconciler(t, &drainState{
+		For: "a", Attempts: 3, LastError: "timed out", RetryAfter: &retryAfter,
+	})
+
+	result, progress, err := r.drain(context.Background(), node, "a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RequeueAfter <= 0 || result.RequeueAfter > time.Minute || progress.err == nil {
+		t.Errorf("drain() = %+v, %+v, want the failed drain requeued for its retry", result, progress)
+	}
+	if _, ok := r.drains.Load(node.Name); ok {
+		t.Errorf("drain() retried the drain before its backoff")
+	}
+
+	for attempts, want := range map[int]time.Duration{1: drainBackoff, 3: 4 * drainBackoff, 20: maxDrainBackoff} {
+		if got := (&drainState{Attempts: attempts}).backoff(); got != want {
+			t.Errorf("backoff() after %d attempts = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestDrainNodeDeleted(t *testing.T) {
+	r, node := newDrainTestReconciler(t, &drainState{For: "a", Attempts: 1})
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = azurev1alpha1.AddToScheme(scheme)
+	r.Client = fake.NewClientBuilder().WithScheme(scheme).Build()
+	ctx, cancel := context.WithCancel(context.Background())
+	r.drains.Store(node.Name, &drainOp{cancel: cancel, done: make(chan struct{})})
+	if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}}); err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Err() == nil {
+		t.Error("Reconcile() did not cancel the drain of the deleted node")
+	}
+	if _, ok := r.drains.Load(node.Name); ok {
+		t.Error("Reconcile() kept the drain of the deleted node")
+	}
+}
+
+// failingPatchClient fails the first patch that fail matches.
+type failingPatchClient struct {
+	client.Client
+	fail func(data string) bool
+}
+
+func (c *failingPatchClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if data, err := patch.Data(obj); err == nil && c.fail != nil && c.fail(string(data)) {
+		c.fail = nil
+		return errors.New("patch failed")
+	}
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func TestDrainMarkDrainedRetried(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = azurev1alpha1.AddToScheme(scheme)
+	h := handlerFor("maintenance", "MaintenanceScheduled", nil, "None", "UncordonOnClear", "Reboot", "Drain")
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node", Annotations: map[string]string{ScheduledEventsAnnotation: "a"}},
+		Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{
+			{Type: "MaintenanceScheduled", Status: corev1.ConditionTrue, Reason: "Reboot"},
+		}},
+	}
+	c := &failingPatchClient{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(&h, node).Build(),
+		fail:   func(data string) bool { return strings.Contains(data, DrainedEventsAnnotation) },
+	}
+	r := &NodeConditionHandlerReconciler{Client: c, Clientset: kubefake.NewSimpleClientset(), Log: log.NullLogger{}}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}}
+
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	v, ok := r.drains.Load(node.Name)
+	if !ok {
+		t.Fatal("Reconcile() did not start draining the node")
+	}
+	<-v.(*drainOp).done
+	if _, err := r.Reconcile(ctx, req); err == nil {
+		t.Fatal("Reconcile() = nil, want the failed patch of the drained events")
+	}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	got := &corev1.Node{}
+	if err := r.Get(ctx, req.NamespacedName, got); err != nil {
+		t.Fatal(err)
+	}
+	if state := drainStateOf(got); state == nil || state.Completed == nil || !drained(got) {
+		t.Errorf("Reconcile() left %+v, want the node drained and its events marked drained", got.Annotations)
+	}
+}
+
+func TestDrainOnce(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = azurev1alpha1.AddToScheme(scheme)
+	h := handlerFor("kernel", "KernelDeadlock", nil, "DockerHung", "Drain", "KernelHasNoDeadlock", "UncordonOnClear")
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node"},
+		Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{
+			{Type: "KernelDeadlock", Status: corev1.ConditionTrue, Reason: "DockerHung"},
+		}},
+	}
+	r := &NodeConditionHandlerReconciler{
+		Client:    fake.NewClientBuilder().WithScheme(scheme).WithObjects(&h, node).Build(),
+		Clientset: kubefake.NewSimpleClientset(),
+		Log:       log.NullLogger{},
+	}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}}
+	reconcile := func() (ctrl.Result, *drainState) {
+		result, err := r.Reconcile(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := &corev1.Node{}
+		if err := r.Get(ctx, req.NamespacedName, got); err != nil {
+			t.Fatal(err)
+		}
+		return result, drainStateOf(got)
+	}
+
+	if _, state := reconcile(); state == nil || state.For != "kernel" || state.Attempts != 1 {
+		t.Fatalf("Reconcile() checkpointed %+v, want the node drained for the kernel handler", state)
+	}
+	v, ok := r.drains.Load(node.Name)
+	if !ok {
+		t.Fatal("Reconcile() did not start draining the node")
+	}
+	<-v.(*drainOp).done
+	_, drained := reconcile()
+	if drained == nil || drained.Completed == nil {
+		t.Fatalf("Reconcile() checkpointed %+v, want the drain completed", drained)
+	}
+
+	for n := 0; n < 2; n++ {
+		result, state := reconcile()
+		if _, ok := r.drains.Load(node.Name); ok || result.RequeueAfter != 0 {
+			t.Fatalf("Reconcile() = %+v, drained the node again", result)
+		}
+		if state == nil || state.Attempts != 1 || !state.StartTime.Equal(&drained.StartTime) {
+			t.Errorf("Reconcile() checkpointed %+v, want %+v kept", state, drained)
+		}
+	}
+	got := &azurev1alpha1.NodeConditionHandler{}
+	if err := r.Get(ctx, client.ObjectKey{Name: h.Name}, got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Status.Nodes) != 1 || got.Status.Nodes[0].Phase != azurev1alpha1.PhaseDrained {
+		t.Errorf("status.nodes = %+v, want the node drained", got.Status.Nodes)
+	}
+}
@@ -4,100 +4,163 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	kctlutil "k8s.io/kubectl/pkg/cmd/util"
 	kctldrain "k8s.io/kubectl/pkg/drain"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	azurev1alpha1 "github.com/juan-lee/nodify/api/v1alpha1"
 )
 
 // NodeConditionHandlerReconciler reconciles a NodeConditionHandler object
 type NodeConditionHandlerReconciler struct {
 	client.Client
-	Clientset *kubernetes.Clientset
+	Clientset kubernetes.Interface
 	Log       logr.Logger
 	Scheme    *runtime.Scheme
+	Recorder  record.EventRecorder
+
+	// leftAlone holds the names of the nodes whose foreign cordon has been reported.
+	leftAlone sync.Map
+	// drains holds the *drainOp running for each node name.
+	drains sync.Map
 }
 
+//+kubebuilder:rbac:groups=azure.microsoft.com,resources=nodeconditionhandlers,verbs=get;list;watch
+//+kubebuilder:rbac:groups=azure.microsoft.com,resources=nodeconditionhandlers/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 //+kubebuilder:rbac:groups="",resources=nodes;pods,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups="",resources=nodes/status;pods/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups="",resources=pods/eviction,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=apps,resources=daemonsets,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=apps,resources=daemonsets/status,verbs=get;update;patch
 
-// SetupWithManager sets up the controller with the Manager.
+// SetupWithManager sets up the controller with the Manager. Every node is reconciled when a
+// NodeConditionHandler changes, since a changed selector may also release nodes. Updates of the
+// status of a handler don't change its generation, and are ignored.
 func (r *NodeConditionHandlerReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&corev1.Node{}).
+		Watches(&source.Kind{Type: &azurev1alpha1.NodeConditionHandler{}}, handler.EnqueueRequestsFromMapFunc(r.allNodes),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
 		Complete(r)
 }
 
+func (r *NodeConditionHandlerReconciler) allNodes(_ client.Object) []reconcile.Request {
+	var nodes corev1.NodeList
+	if err := r.List(context.Background(), &nodes); err != nil {
+		r.Log.Error(err, "unable to list Nodes")
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(nodes.Items))
+	for n := range nodes.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: nodes.Items[n].Name}})
+	}
+	return requests
+}
+
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
 func (r *NodeConditionHandlerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := r.Log.WithValues("nodes", req.NamespacedName)
 
+	var handlers azurev1alpha1.NodeConditionHandlerList
+	if err := r.List(ctx, &handlers); err != nil {
+		return ctrl.Result{}, err
+	}
 	var node corev1.Node
 	if err := r.Get(ctx, req.NamespacedName, &node); err != nil {
+		if apierrors.IsNotFound(err) {
+			r.cancelDrain(req.Name)
+			return ctrl.Result{}, r.updateStatuses(ctx, req.Name, nil, handlers.Items, nil, nil)
+		}
 		log.Error(err, "unable to fetch Node")
-		return ctrl.Result{}, client.IgnoreNotFound(err)
+		return ctrl.Result{}, err
 	}
 
-	nodeCondition, err := getMaintenanceCondition(&node)
+	p := newPlan(&node, handlersOrDefault(handlers.Items), log)
+	for _, a := range p.actions {
+		log.Info("Handling condition", "handler", a.Handler, "condition", a.Condition, "action", a.Action)
+	}
+	result, progress, err := r.execute(ctx, &node, p)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
+	return result, r.updateStatuses(ctx, node.Name, &node, handlers.Items, p, progress)
+}
 
-	switch nodeCondition.Reason {
-	case "None":
-		log.Info("No maintenance required", "condition", nodeCondition)
-		if node.Spec.Unschedulable {
-			if err := r.uncordon(&node); err != nil {
-				return ctrl.Result{}, err
-			}
+// execute carries out the plan on the node. It returns the progress of the drain if the plan
+// drains the node, and requeues the node until the drain is done.
+func (r *NodeConditionHandlerReconciler) execute(ctx context.Context, node *corev1.Node, p *plan) (ctrl.Result, *drainProgress, error) {
+	log := r.Log.WithValues("node", node.Name)
+	if err := r.applyTaints(ctx, node, p); err != nil {
+		return ctrl.Result{}, nil, err
+	}
+	if p.drain {
+		owner := p.cordonedFor(node)
+		if state := drainStateOf(node); state != nil && state.For == owner && state.Completed != nil {
+			// The node may have been drained without marking its events drained, if that failed.
+			return ctrl.Result{}, state.progress(), r.markDrained(ctx, node)
 		}
-	case "Freeze":
-		log.Info("The Virtual Machine is scheduled to pause for a few seconds.", "condition", nodeCondition)
-	case "Reboot", "Redeploy", "Prempt", "Terminate":
-		log.Info("Maintenance required", "condition", nodeCondition)
-		if err := r.cordonAndDrain(&node); err != nil {
-			return ctrl.Result{}, err
+		if drained(node) {
+			log.Info("Node already drained for scheduled maintenance")
+			return ctrl.Result{}, nil, nil
 		}
+		log.Info("Maintenance required", "events", scheduledEvents(node))
+		return r.drain(ctx, node, owner, p.drainOptions)
 	}
-
-	return ctrl.Result{}, nil
-}
-
-func (r *NodeConditionHandlerReconciler) cordonAndDrain(node *corev1.Node) error {
-	log := r.Log.WithValues("node", node.Name)
-	helper := newDrainHelper(r.Clientset, log)
-	log.Info("Cordoning node")
-	if err := kctldrain.RunCordonOrUncordon(helper, node, true); err != nil {
-		return err
+	if err := r.stopDrain(ctx, node); err != nil {
+		return ctrl.Result{}, nil, err
 	}
-	log.Info("Draining node")
-	if err := kctldrain.RunNodeDrain(helper, node.Name); err != nil {
-		log.Info("Errors draining node", "err", err)
+	switch {
+	case p.cordon:
+		if err := r.cordon(ctx, node, p.cordonedFor(node)); err != nil {
+			return ctrl.Result{}, nil, err
+		}
+	case p.uncordon:
+		if err := r.uncordon(ctx, node); err != nil {
+			return ctrl.Result{}, nil, err
+		}
+		if err := r.clearDrained(ctx, node); err != nil {
+			return ctrl.Result{}, nil, err
+		}
 	}
-	return nil
+	if p.prepared {
+		if err := r.markDrained(ctx, node); err != nil {
+			return ctrl.Result{}, nil, err
+		}
+	}
+	return ctrl.Result{}, nil, nil
 }
 
-func (r *NodeConditionHandlerReconciler) uncordon(node *corev1.Node) error {
-	log := r.Log.WithValues("node", node.Name)
-	helper := newDrainHelper(r.Clientset, r.Log)
-	log.Info("Uncordoning node")
-	if err := kctldrain.RunCordonOrUncordon(helper, node, false); err != nil {
-		return err
+// applyTaints adds and removes the taints of the plan on the node.
+func (r *NodeConditionHandlerReconciler) applyTaints(ctx context.Context, node *corev1.Node, p *plan) error {
+	taints, changed := p.taint(node.Spec.Taints)
+	if !changed {
+		return nil
 	}
-	return nil
+	patch := client.MergeFrom(node.DeepCopy())
+	node.Spec.Taints = taints
+	return r.Patch(ctx, node, patch)
 }
 
-func newDrainHelper(cs *kubernetes.Clientset, log logr.Logger) *kctldrain.Helper {
+func newDrainHelper(cs kubernetes.Interface, log logr.Logger) *kctldrain.Helper {
 	return &kctldrain.Helper{
 		Client:              cs,
 		Force:               true,
@@ -119,15 +182,96 @@ func newDrainHelper(cs *kubernetes.Clientset, log logr.Logger) *kctldrain.Helper
 	}
 }
 
+// withDrainOptions overrides the defaults of helper with opts.
+func withDrainOptions(helper *kctldrain.Helper, opts *azurev1alpha1.DrainOptions) error {
+	if opts == nil {
+		return nil
+	}
+	if opts.Force != nil {
+		helper.Force = *opts.Force
+	}
+	if opts.IgnoreAllDaemonSets != nil {
+		helper.IgnoreAllDaemonSets = *opts.IgnoreAllDaemonSets
+	}
+	if opts.DeleteEmptyDirData != nil {
+		helper.DeleteEmptyDirData = *opts.DeleteEmptyDirData
+	}
+	if opts.GracePeriodSeconds != nil {
+		helper.GracePeriodSeconds = *opts.GracePeriodSeconds
+	}
+	if opts.TimeoutSeconds != nil {
+		helper.Timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	if opts.PodSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(opts.PodSelector)
+		if err != nil {
+			return err
+		}
+		helper.PodSelector = selector.String()
+	}
+	helper.SkipWaitForDeleteTimeoutSeconds = opts.SkipWaitForDeleteTimeoutSeconds
+	helper.DisableEviction = opts.DisableEviction
+	return nil
+}
+
+// eventTypes are the EventTypes of scheduled events in the order of how disruptive they are to the node.
+var eventTypes = []string{"Freeze", "Reboot", "Redeploy", "Preempt", "Terminate"}
+
+// getMaintenanceCondition returns the MaintenanceScheduled condition the nodify daemon publishes.
+// If the daemon publishes a condition per EventType instead, e.g. RebootScheduled, the
+// MaintenanceScheduled condition is derived from the most disruptive one that is true.
 func getMaintenanceCondition(node *corev1.Node) (*corev1.NodeCondition, error) {
+	var found bool
+	var derived *corev1.NodeCondition
 	for n, condition := range node.Status.Conditions {
 		if condition.Type == "MaintenanceScheduled" {
 			return &node.Status.Conditions[n], nil
 		}
+		for severity, eventType := range eventTypes {
+			if string(condition.Type) != eventType+"Scheduled" {
+				continue
+			}
+			found = true
+			if condition.Status == corev1.ConditionTrue && (derived == nil || severity > severityOf(derived.Reason)) {
+				derived = node.Status.Conditions[n].DeepCopy()
+			}
+		}
+	}
+	if derived != nil {
+		derived.Type = "MaintenanceScheduled"
+		return derived, nil
+	}
+	if found {
+		return &corev1.NodeCondition{
+			Type:    "MaintenanceScheduled",
+			Status:  corev1.ConditionFalse,
+			Reason:  "None",
+			Message: "No maintenance scheduled.",
+		}, nil
 	}
 	return nil, errors.New("missing MaintenanceScheduled NodeCondition")
 }
 
+func severityOf(eventType string) int {
+	for n := range eventTypes {
+		if eventTypes[n] == eventType {
+			return n
+		}
+	}
+	return -1
+}
+
+// daemonStopped reports whether the nodify daemon on the node has shut down, in which case its
+// MaintenanceScheduled condition is no longer kept up to date.
+func daemonStopped(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == "ScheduledEventsUnavailable" {
+			return condition.Status == corev1.ConditionUnknown && condition.Reason == "DaemonStopped"
+		}
+	}
+	return false
+}
+
 // writer implements io.Writer interface as a pass-through for logr.
 type writer struct {
 	logFunc func(msg string, args ...interface{})
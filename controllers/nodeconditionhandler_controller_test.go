@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	azurev1alpha1 "github.com/juan-lee/nodify/api/v1alpha1"
+)
+
+func TestGetMaintenanceCondition(t *testing.T) {
+	condition := func(conditionType string, status corev1.ConditionStatus, reason string) corev1.NodeCondition {
+		return corev1.NodeCondition{Type: corev1.NodeConditionType(conditionType), Status: status, Reason: reason}
+	}
+	tests := map[string]struct {
+		conditions []corev1.NodeCondition
+		want       string
+		wantErr    bool
+	}{
+		"single": {
+			conditions: []corev1.NodeCondition{condition("MaintenanceScheduled", corev1.ConditionTrue, "Reboot")},
+			want:       "Reboot",
+		},
+		"per event type": {
+			conditions: []corev1.NodeCondition{
+				condition("FreezeScheduled", corev1.ConditionTrue, "Freeze"),
+				condition("RebootScheduled", corev1.ConditionTrue, "Reboot"),
+				condition("TerminateScheduled", corev1.ConditionFalse, "None"),
+			},
+			want: "Reboot",
+		},
+		"per event type without maintenance": {
+			conditions: []corev1.NodeCondition{
+				condition("FreezeScheduled", corev1.ConditionFalse, "None"),
+				condition("RebootScheduled", corev1.ConditionFalse, "None"),
+			},
+			want: "None",
+		},
+		"both prefer single": {
+			conditions: []corev1.NodeCondition{
+				condition("FreezeScheduled", corev1.ConditionTrue, "Freeze"),
+				condition("MaintenanceScheduled", corev1.ConditionTrue, "Freeze"),
+			},
+			want: "Freeze",
+		},
+		"missing": {
+			conditions: []corev1.NodeCondition{condition("Ready", corev1.ConditionTrue, "KubeletReady")},
+			wantErr:    true,
+		},
+	}
+	for name, tt := range tests {
+		node := &corev1.Node{Status: corev1.NodeStatus{Conditions: tt.conditions}}
+		got, err := getMaintenanceCondition(node)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: getMaintenanceCondition() = %+v, want error", name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: getMaintenanceCondition() error = %v", name, err)
+			continue
+		}
+		if got.Type != "MaintenanceScheduled" || got.Reason != tt.want {
+			t.Errorf("%s: getMaintenanceCondition() = %+v, want MaintenanceScheduled/%s", name, got, tt.want)
+		}
+	}
+}
+
+func TestWithDrainOptions(t *testing.T) {
+	helper := newDrainHelper(nil, log.NullLogger{})
+	if err := withDrainOptions(helper, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !helper.Force || !helper.DeleteEmptyDirData || helper.Timeout != 60*time.Second {
+		t.Errorf("withDrainOptions(nil) = %+v, want the defaults", helper)
+	}
+
+	keep, timeout := false, 300
+	err := withDrainOptions(helper, &azurev1alpha1.DrainOptions{
+		Force:              &keep,
+		DeleteEmptyDirData: &keep,
+		TimeoutSeconds:     &timeout,
+		PodSelector:        &metav1.LabelSelector{MatchLabels: map[string]string{"app": "cache"}},
+		DisableEviction:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if helper.Force || helper.DeleteEmptyDirData || !helper.IgnoreAllDaemonSets {
+		t.Errorf("withDrainOptions() = %+v, want only the options set overridden", helper)
+	}
+	if helper.Timeout != 5*time.Minute || helper.PodSelector != "app=cache" || !helper.DisableEviction {
+		t.Errorf("withDrainOptions() = %+v, want the timeout, pod selector and eviction overridden", helper)
+	}
+
+	err = withDrainOptions(helper, &azurev1alpha1.DrainOptions{
+		PodSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "app", Operator: "Near"}}},
+	})
+	if err == nil {
+		t.Error("withDrainOptions() accepted an invalid pod selector")
+	}
+}
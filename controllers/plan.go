@@ -0,0 +1,247 @@
+package controllers
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	azurev1alpha1 "github.com/juan-lee/nodify/api/v1alpha1"
+)
+
+// taintKeyPrefix is followed by the condition type in the key of the default taint of a handler.
+const taintKeyPrefix = "nodify.azure.microsoft.com/"
+
+// defaultHandler handles the MaintenanceScheduled condition while no NodeConditionHandler exists,
+// so nodes are drained for scheduled maintenance out of the box. It is the same as the sample in
+// config/samples/azure_v1alpha1_nodeconditionhandler.yaml.
+var defaultHandler = azurev1alpha1.NodeConditionHandler{
+	ObjectMeta: metav1.ObjectMeta{Name: "maintenance-scheduled"},
+	Spec: azurev1alpha1.NodeConditionHandlerSpec{
+		ConditionType: "MaintenanceScheduled",
+		Reasons: []azurev1alpha1.ReasonAction{
+			{Reason: "None", Action: azurev1alpha1.ActionUncordonOnClear},
+			{Reason: "Freeze", Action: azurev1alpha1.ActionNone},
+			{Reason: "Reboot", Action: azurev1alpha1.ActionDrain},
+			{Reason: "Redeploy", Action: azurev1alpha1.ActionDrain},
+			{Reason: "Preempt", Action: azurev1alpha1.ActionDrain},
+			{Reason: "Terminate", Action: azurev1alpha1.ActionDrain},
+		},
+	},
+}
+
+// handlersOrDefault returns handlers, or defaultHandler if there are none.
+func handlersOrDefault(handlers []azurev1alpha1.NodeConditionHandler) []azurev1alpha1.NodeConditionHandler {
+	if len(handlers) == 0 {
+		return []azurev1alpha1.NodeConditionHandler{defaultHandler}
+	}
+	return handlers
+}
+
+// handlerAction is the Action a handler selecting the node takes for the reason of its condition.
+type handlerAction struct {
+	Handler   string
+	Condition *corev1.NodeCondition
+	Action    azurev1alpha1.Action
+}
+
+// plan is what the controller does with a node to satisfy every handler selecting it. The most
+// disruptive action wins: a node is only uncordoned if no handler wants it cordoned or drained.
+type plan struct {
+	actions  []handlerAction
+	drain    bool
+	cordon   bool
+	uncordon bool
+	// prepared is set if a handler of a condition reported by the nodify daemon acted on a reason
+	// other than a cleared condition, so the node is ready for the events scheduled for it once the
+	// plan completed.
+	prepared bool
+	// drainOptions are those of the first handler draining the node.
+	drainOptions *azurev1alpha1.DrainOptions
+	taints       []corev1.Taint
+	untaints     []corev1.Taint
+}
+
+// newPlan evaluates the node against every handler. Handlers are evaluated in the order of their
+// names, and handlers with an invalid node selector are skipped. The taint of a handler is removed
+// unless it selects the node and taints it for the reason of its condition.
+func newPlan(node *corev1.Node, handlers []azurev1alpha1.NodeConditionHandler, log logr.Logger) *plan {
+	sorted := make([]*azurev1alpha1.NodeConditionHandler, 0, len(handlers))
+	for n := range handlers {
+		sorted = append(sorted, &handlers[n])
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	p := &plan{}
+	for _, h := range sorted {
+		selected, err := selects(h, node)
+		if err != nil {
+			log.Error(err, "Invalid node selector", "handler", h.Name)
+		}
+		var condition *corev1.NodeCondition
+		if selected {
+			condition = conditionFor(node, h.Spec.ConditionType)
+		}
+		var action azurev1alpha1.Action
+		var ok bool
+		if condition != nil {
+			action, ok = actionFor(&h.Spec, condition.Reason)
+		}
+		if action != azurev1alpha1.ActionTaint {
+			p.untaints = append(p.untaints, taintFor(&h.Spec, ""))
+		}
+		if !ok {
+			continue
+		}
+		p.actions = append(p.actions, handlerAction{Handler: h.Name, Condition: condition, Action: action})
+		switch action {
+		case azurev1alpha1.ActionDrain:
+			if !p.drain {
+				p.drainOptions = h.Spec.Drain
+			}
+			p.drain = true
+		case azurev1alpha1.ActionCordon:
+			p.cordon = true
+		case azurev1alpha1.ActionTaint:
+			p.taints = append(p.taints, taintFor(&h.Spec, condition.Reason))
+		case azurev1alpha1.ActionUncordonOnClear:
+			if maintenanceCondition(h.Spec.ConditionType) && daemonStopped(node) {
+				log.Info("The nodify daemon stopped, not acting on a stale condition", "handler", h.Name, "condition", condition)
+				continue
+			}
+			p.uncordon = true
+		}
+		if action != azurev1alpha1.ActionUncordonOnClear && maintenanceCondition(h.Spec.ConditionType) {
+			p.prepared = true
+		}
+	}
+	if p.drain || p.cordon {
+		p.uncordon = false
+	}
+	return p
+}
+
+// actionOf returns the action the handler takes on the node, or nil if it takes none.
+func (p *plan) actionOf(handler string) *handlerAction {
+	for n := range p.actions {
+		if p.actions[n].Handler == handler {
+			return &p.actions[n]
+		}
+	}
+	return nil
+}
+
+// selects reports whether the node selector of h selects node.
+func selects(h *azurev1alpha1.NodeConditionHandler, node *corev1.Node) (bool, error) {
+	if h.Spec.NodeSelector == nil {
+		return true, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(h.Spec.NodeSelector)
+	if err != nil {
+		return false, err
+	}
+	return selector.Matches(labels.Set(node.Labels)), nil
+}
+
+// conditionFor returns the condition of conditionType of the node, or nil if it has none.
+func conditionFor(node *corev1.Node, conditionType string) *corev1.NodeCondition {
+	if conditionType == "MaintenanceScheduled" {
+		condition, err := getMaintenanceCondition(node)
+		if err != nil {
+			return nil
+		}
+		return condition
+	}
+	for n := range node.Status.Conditions {
+		if string(node.Status.Conditions[n].Type) == conditionType {
+			return &node.Status.Conditions[n]
+		}
+	}
+	return nil
+}
+
+// maintenanceCondition reports whether conditionType is reported by the nodify daemon.
+func maintenanceCondition(conditionType string) bool {
+	if conditionType == "MaintenanceScheduled" {
+		return true
+	}
+	for _, eventType := range eventTypes {
+		if conditionType == eventType+"Scheduled" {
+			return true
+		}
+	}
+	return false
+}
+
+// actionFor returns the Action spec takes for reason, if any.
+func actionFor(spec *azurev1alpha1.NodeConditionHandlerSpec, reason string) (azurev1alpha1.Action, bool) {
+	for _, r := range spec.Reasons {
+		if r.Reason == reason {
+			return r.Action, true
+		}
+	}
+	return "", false
+}
+
+// taintFor returns the taint spec applies for reason.
+func taintFor(spec *azurev1alpha1.NodeConditionHandlerSpec, reason string) corev1.Taint {
+	if spec.Taint != nil {
+		return *spec.Taint
+	}
+	return corev1.Taint{
+		Key:    taintKeyPrefix + spec.ConditionType,
+		Value:  reason,
+		Effect: corev1.TaintEffectNoSchedule,
+	}
+}
+
+// taint returns the taints of the node with the taints of p applied, and whether they changed.
+// Taints are matched by key and effect, so a changed reason replaces the value of a taint.
+func (p *plan) taint(taints []corev1.Taint) ([]corev1.Taint, bool) {
+	changed := false
+	result := make([]corev1.Taint, 0, len(taints)+len(p.taints))
+	for n := range taints {
+		if p.untainted(&taints[n]) {
+			changed = true
+			continue
+		}
+		result = append(result, taints[n])
+	}
+	for n := range p.taints {
+		found := false
+		for m := range result {
+			if !result[m].MatchTaint(&p.taints[n]) {
+				continue
+			}
+			found = true
+			if result[m].Value != p.taints[n].Value {
+				result[m].Value = p.taints[n].Value
+				changed = true
+			}
+		}
+		if !found {
+			result = append(result, p.taints[n])
+			changed = true
+		}
+	}
+	return result, changed
+}
+
+// untainted reports whether p removes taint. Taints keyed with taintKeyPrefix that no handler
+// applies are removed too, e.g. those of deleted handlers.
+func (p *plan) untainted(taint *corev1.Taint) bool {
+	for n := range p.taints {
+		if p.taints[n].MatchTaint(taint) {
+			return false
+		}
+	}
+	for n := range p.untaints {
+		if p.untaints[n].MatchTaint(taint) {
+			return true
+		}
+	}
+	return strings.HasPrefix(taint.Key, taintKeyPrefix)
+}
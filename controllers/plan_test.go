@@ -0,0 +1,213 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	azurev1alpha1 "github.com/juan-lee/nodify/api/v1alpha1"
+)
+
+func handlerFor(name, conditionType string, selector map[string]string, reasons ...string) azurev1alpha1.NodeConditionHandler {
+	h := azurev1alpha1.NodeConditionHandler{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       azurev1alpha1.NodeConditionHandlerSpec{ConditionType: conditionType},
+	}
+	if selector != nil {
+		h.Spec.NodeSelector = &metav1.LabelSelector{MatchLabels: selector}
+	}
+	for n := 0; n+1 < len(reasons); n += 2 {
+		h.Spec.Reasons = append(h.Spec.Reasons, azurev1alpha1.ReasonAction{
+			Reason: reasons[n],
+			Action: azurev1alpha1.Action(reasons[n+1]),
+		})
+	}
+	return h
+}
+
+func TestNewPlan(t *testing.T) {
+	maintenance := handlerFor("maintenance", "MaintenanceScheduled", nil,
+		"None", "UncordonOnClear", "Freeze", "None", "Reboot", "Drain")
+	spot := handlerFor("spot", "PreemptScheduled", map[string]string{"priority": "Spot"},
+		"None", "UncordonOnClear", "Preempt", "Cordon")
+	kernel := handlerFor("kernel", "KernelDeadlock", nil, "DockerHung", "Taint")
+
+	tests := map[string]struct {
+		labels     map[string]string
+		conditions []corev1.NodeCondition
+		handlers   []azurev1alpha1.NodeConditionHandler
+		want       plan
+		wantTaints int
+	}{
+		"drain": {
+			conditions: []corev1.NodeCondition{{Type: "MaintenanceScheduled", Status: corev1.ConditionTrue, Reason: "Reboot"}},
+			handlers:   []azurev1alpha1.NodeConditionHandler{maintenance},
+			want:       plan{drain: true, prepared: true},
+		},
+		"unlisted reason": {
+			conditions: []corev1.NodeCondition{{Type: "MaintenanceScheduled", Status: corev1.ConditionTrue, Reason: "Terminate"}},
+			handlers:   []azurev1alpha1.NodeConditionHandler{maintenance},
+			want:       plan{},
+		},
+		"none marks drained": {
+			conditions: []corev1.NodeCondition{{Type: "MaintenanceScheduled", Status: corev1.ConditionTrue, Reason: "Freeze"}},
+			handlers:   []azurev1alpha1.NodeConditionHandler{maintenance},
+			want:       plan{prepared: true},
+		},
+		"cordon wins over uncordon": {
+			labels: map[string]string{"priority": "Spot"},
+			conditions: []corev1.NodeCondition{
+				{Type: "MaintenanceScheduled", Status: corev1.ConditionFalse, Reason: "None"},
+				{Type: "PreemptScheduled", Status: corev1.ConditionTrue, Reason: "Preempt"},
+			},
+			handlers: []azurev1alpha1.NodeConditionHandler{maintenance, spot},
+			want:     plan{cordon: true, prepared: true},
+		},
+		"selector excludes": {
+			conditions: []corev1.NodeCondition{
+				{Type: "MaintenanceScheduled", Status: corev1.ConditionFalse, Reason: "None"},
+				{Type: "PreemptScheduled", Status: corev1.ConditionTrue, Reason: "Preempt"},
+			},
+			handlers: []azurev1alpha1.NodeConditionHandler{maintenance, spot},
+			want:     plan{uncordon: true},
+		},
+		"daemon stopped": {
+			conditions: []corev1.NodeCondition{
+				{Type: "MaintenanceScheduled", Status: corev1.ConditionFalse, Reason: "None"},
+				{Type: "ScheduledEventsUnavailable", Status: corev1.ConditionUnknown, Reason: "DaemonStopped"},
+			},
+			handlers: []azurev1alpha1.NodeConditionHandler{maintenance},
+			want:     plan{},
+		},
+		"missing condition": {
+			conditions: []corev1.NodeCondition{{Type: "Ready", Status: corev1.ConditionTrue, Reason: "KubeletReady"}},
+			handlers:   []azurev1alpha1.NodeConditionHandler{maintenance, kernel},
+			want:       plan{},
+		},
+		"taint": {
+			conditions: []corev1.NodeCondition{{Type: "KernelDeadlock", Status: corev1.ConditionTrue, Reason: "DockerHung"}},
+			handlers:   []azurev1alpha1.NodeConditionHandler{kernel},
+			want:       plan{},
+			wantTaints: 1,
+		},
+		"other condition doesn't prepare for maintenance": {
+			conditions: []corev1.NodeCondition{
+				{Type: "MaintenanceScheduled", Status: corev1.ConditionTrue, Reason: "Reboot"},
+				{Type: "KernelDeadlock", Status: corev1.ConditionTrue, Reason: "DockerHung"},
+			},
+			handlers:   []azurev1alpha1.NodeConditionHandler{kernel},
+			want:       plan{},
+			wantTaints: 1,
+		},
+	}
+	for name, tt := range tests {
+		node := &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node", Labels: tt.labels},
+			Status:     corev1.NodeStatus{Conditions: tt.conditions},
+		}
+		got := newPlan(node, tt.handlers, log.NullLogger{})
+		if len(got.taints) != tt.wantTaints {
+			t.Errorf("%s: taints = %+v, want %d", name, got.taints, tt.wantTaints)
+		}
+		got.actions, got.taints, got.untaints = nil, nil, nil
+		if !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("%s: newPlan() = %+v, want %+v", name, *got, tt.want)
+		}
+	}
+}
+
+func TestDefaultHandler(t *testing.T) {
+	node := &corev1.Node{Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{
+		{Type: "MaintenanceScheduled", Status: corev1.ConditionTrue, Reason: "Reboot"},
+	}}}
+	if p := newPlan(node, handlersOrDefault(nil), log.NullLogger{}); !p.drain {
+		t.Errorf("newPlan() = %+v without handlers, want the node drained for the Reboot", *p)
+	}
+	spot := handlerFor("spot", "PreemptScheduled", nil, "Preempt", "Cordon")
+	if p := newPlan(node, handlersOrDefault([]azurev1alpha1.NodeConditionHandler{spot}), log.NullLogger{}); p.drain {
+		t.Errorf("newPlan() = %+v, want only the handlers that exist applied", *p)
+	}
+}
+
+func TestPlanTaint(t *testing.T) {
+	kernel := handlerFor("kernel", "KernelDeadlock", nil, "DockerHung", "Taint", "KernelHasNoDeadlock", "None")
+	other := corev1.Taint{Key: "example.com/other", Effect: corev1.TaintEffectNoExecute}
+	node := &corev1.Node{
+		Spec: corev1.NodeSpec{Taints: []corev1.Taint{other}},
+		Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{
+			{Type: "KernelDeadlock", Status: corev1.ConditionTrue, Reason: "DockerHung"},
+		}},
+	}
+	handlers := []azurev1alpha1.NodeConditionHandler{kernel}
+
+	taints, changed := newPlan(node, handlers, log.NullLogger{}).taint(node.Spec.Taints)
+	want := corev1.Taint{Key: "nodify.azure.microsoft.com/KernelDeadlock", Value: "DockerHung", Effect: corev1.TaintEffectNoSchedule}
+	if !changed || !reflect.DeepEqual(taints, []corev1.Taint{other, want}) {
+		t.Fatalf("taint() = %+v, %v, want the handler's taint added", taints, changed)
+	}
+	node.Spec.Taints = taints
+	if _, changed := newPlan(node, handlers, log.NullLogger{}).taint(node.Spec.Taints); changed {
+		t.Errorf("taint() changed the taints again")
+	}
+
+	node.Status.Conditions[0].Reason = "KernelHasNoDeadlock"
+	taints, changed = newPlan(node, handlers, log.NullLogger{}).taint(node.Spec.Taints)
+	if !changed || !reflect.DeepEqual(taints, []corev1.Taint{other}) {
+		t.Errorf("taint() = %+v, %v, want the handler's taint removed", taints, changed)
+	}
+}
+
+func TestPlanUntaint(t *testing.T) {
+	kernel := handlerFor("kernel", "KernelDeadlock", map[string]string{"pool": "a"}, "DockerHung", "Taint")
+	custom := handlerFor("custom", "KernelDeadlock", nil, "DockerHung", "Taint")
+	custom.Spec.Taint = &corev1.Taint{Key: "example.com/kernel", Effect: corev1.TaintEffectNoExecute}
+	other := corev1.Taint{Key: "example.com/other", Effect: corev1.TaintEffectNoExecute}
+	tainted := []corev1.Taint{
+		other,
+		{Key: "nodify.azure.microsoft.com/KernelDeadlock", Value: "DockerHung", Effect: corev1.TaintEffectNoSchedule},
+		{Key: "example.com/kernel", Effect: corev1.TaintEffectNoExecute},
+	}
+	deadlock := []corev1.NodeCondition{{Type: "KernelDeadlock", Status: corev1.ConditionTrue, Reason: "DockerHung"}}
+
+	tests := map[string]struct {
+		labels     map[string]string
+		conditions []corev1.NodeCondition
+		handlers   []azurev1alpha1.NodeConditionHandler
+		want       []corev1.Taint
+	}{
+		"still tainted": {
+			labels: map[string]string{"pool": "a"}, conditions: deadlock,
+			handlers: []azurev1alpha1.NodeConditionHandler{kernel, custom},
+			want:     tainted,
+		},
+		"selector changed": {
+			labels: map[string]string{"pool": "b"}, conditions: deadlock,
+			handlers: []azurev1alpha1.NodeConditionHandler{kernel, custom},
+			want:     []corev1.Taint{other, tainted[2]},
+		},
+		"condition removed": {
+			labels:   map[string]string{"pool": "a"},
+			handlers: []azurev1alpha1.NodeConditionHandler{kernel, custom},
+			want:     []corev1.Taint{other},
+		},
+		"handler deleted": {
+			labels: map[string]string{"pool": "a"}, conditions: deadlock,
+			handlers: []azurev1alpha1.NodeConditionHandler{custom},
+			want:     []corev1.Taint{other, tainted[2]},
+		},
+	}
+	for name, tt := range tests {
+		node := &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node", Labels: tt.labels},
+			Spec:       corev1.NodeSpec{Taints: append([]corev1.Taint(nil), tainted...)},
+			Status:     corev1.NodeStatus{Conditions: tt.conditions},
+		}
+		taints, _ := newPlan(node, tt.handlers, log.NullLogger{}).taint(node.Spec.Taints)
+		if !reflect.DeepEqual(taints, tt.want) {
+			t.Errorf("%s: taint() = %+v, want %+v", name, taints, tt.want)
+		}
+	}
+}
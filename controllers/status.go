@@ -0,0 +1,245 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	azurev1alpha1 "github.com/juan-lee/nodify/api/v1alpha1"
+)
+
+// uncordonedRetention is how long a node stays in the status of a handler after it was uncordoned.
+const uncordonedRetention = time.Hour
+
+// drainProgress is what the controller observed draining a node.
+type drainProgress struct {
+	started       *metav1.Time
+	completed     *metav1.Time
+	err           error
+	podsRemaining int
+}
+
+// maintenanceStatus returns the status of the node for the handler taking a, or nil if the node
+// is not under maintenance for it. a is nil if the handler does not act on the node, previous is
+// the status last reported, if any, and progress is set if the node is being drained.
+func maintenanceStatus(node *corev1.Node, a *handlerAction, previous *azurev1alpha1.NodeMaintenanceStatus,
+	progress *drainProgress, now metav1.Time) *azurev1alpha1.NodeMaintenanceStatus {
+	if a == nil || a.Action == azurev1alpha1.ActionNone {
+		return nil
+	}
+	status := &azurev1alpha1.NodeMaintenanceStatus{Name: node.Name, Reason: a.Condition.Reason}
+	if previous != nil {
+		status.DrainStartTime, status.DrainCompletionTime = previous.DrainStartTime, previous.DrainCompletionTime
+	}
+	for _, event := range scheduledEvents(node) {
+		status.EventIDs = append(status.EventIDs, event.EventID)
+		if event.NotBefore != nil && (status.NotBefore == nil || event.NotBefore.Before(status.NotBefore.Time)) {
+			notBefore := metav1.NewTime(*event.NotBefore)
+			status.NotBefore = &notBefore
+		}
+	}
+
+	switch a.Action {
+	case azurev1alpha1.ActionUncordonOnClear:
+		if previous == nil {
+			return nil
+		}
+		switch {
+		case !node.Spec.Unschedulable:
+			status.Phase = azurev1alpha1.PhaseUncordoned
+		case !ownsCordon(node):
+			status.Phase = azurev1alpha1.PhaseUncordoned
+			status.Message = "The node was left cordoned, nodify did not cordon it."
+		default:
+			status.Phase = azurev1alpha1.PhaseAwaitingRecovery
+			status.Message = "The condition cleared, the node is still cordoned."
+		}
+		if previous.Phase == status.Phase && now.Sub(previous.LastTransitionTime.Time) > uncordonedRetention {
+			return nil
+		}
+	case azurev1alpha1.ActionDrain:
+		drainPhase(status, node, previous, progress)
+	case azurev1alpha1.ActionCordon:
+		status.Phase = azurev1alpha1.PhasePending
+		if node.Spec.Unschedulable {
+			status.Phase = azurev1alpha1.PhaseCordoned
+		}
+	default:
+		status.Phase = azurev1alpha1.PhasePending
+	}
+	if status.Phase != azurev1alpha1.PhaseUncordoned && status.Phase != azurev1alpha1.PhaseFailed &&
+		status.Phase != azurev1alpha1.PhaseDraining && recovering(node) {
+		status.Phase = azurev1alpha1.PhaseAwaitingRecovery
+	}
+
+	status.LastTransitionTime = now
+	if previous != nil && previous.Phase == status.Phase {
+		status.LastTransitionTime = previous.LastTransitionTime
+	}
+	return status
+}
+
+// drainPhase sets the phase of the node for a handler draining it.
+func drainPhase(status *azurev1alpha1.NodeMaintenanceStatus, node *corev1.Node,
+	previous *azurev1alpha1.NodeMaintenanceStatus, progress *drainProgress) {
+	switch {
+	case progress != nil:
+		status.DrainStartTime, status.DrainCompletionTime = progress.started, progress.completed
+		switch {
+		case progress.err != nil:
+			status.Phase = azurev1alpha1.PhaseFailed
+			status.Message = fmt.Sprintf("Failed to drain the node: %v", progress.err)
+			status.PodsRemaining = progress.podsRemaining
+		case progress.completed != nil:
+			status.Phase = azurev1alpha1.PhaseDrained
+		default:
+			status.Phase = azurev1alpha1.PhaseDraining
+			status.PodsRemaining = progress.podsRemaining
+		}
+	case drained(node):
+		status.Phase = azurev1alpha1.PhaseDrained
+	case previous != nil && (previous.Phase == azurev1alpha1.PhaseFailed || previous.Phase == azurev1alpha1.PhaseDrained):
+		status.Phase, status.Message, status.PodsRemaining = previous.Phase, previous.Message, previous.PodsRemaining
+	default:
+		status.Phase = azurev1alpha1.PhasePending
+	}
+}
+
+// recovering reports whether the maintenance of the node started, or the node is not ready.
+func recovering(node *corev1.Node) bool {
+	for _, event := range scheduledEvents(node) {
+		if event.EventStatus == "Started" {
+			return true
+		}
+	}
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status != corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// setNodeStatus replaces the status of the node called name with node, removing it if node is nil.
+func setNodeStatus(status *azurev1alpha1.NodeConditionHandlerStatus, name string, node *azurev1alpha1.NodeMaintenanceStatus) {
+	nodes := make([]azurev1alpha1.NodeMaintenanceStatus, 0, len(status.Nodes)+1)
+	for n := range status.Nodes {
+		if status.Nodes[n].Name != name {
+			nodes = append(nodes, status.Nodes[n])
+		}
+	}
+	if node != nil {
+		nodes = append(nodes, *node)
+	}
+	if len(nodes) == 0 {
+		nodes = nil
+	}
+	status.Nodes = nodes
+}
+
+func findNodeStatus(status *azurev1alpha1.NodeConditionHandlerStatus, name string) *azurev1alpha1.NodeMaintenanceStatus {
+	for n := range status.Nodes {
+		if status.Nodes[n].Name == name {
+			return &status.Nodes[n]
+		}
+	}
+	return nil
+}
+
+// summarize updates the counters and conditions of the status of h from its nodes.
+func summarize(h *azurev1alpha1.NodeConditionHandler, status *azurev1alpha1.NodeConditionHandlerStatus) {
+	status.ObservedGeneration = h.Generation
+	status.NodesUnderMaintenance, status.NodesDraining, status.NodesFailed = 0, 0, 0
+	for n := range status.Nodes {
+		switch status.Nodes[n].Phase {
+		case azurev1alpha1.PhaseUncordoned:
+			continue
+		case azurev1alpha1.PhaseDraining:
+			status.NodesDraining++
+		case azurev1alpha1.PhaseFailed:
+			status.NodesFailed++
+		}
+		status.NodesUnderMaintenance++
+	}
+
+	ready := metav1.Condition{Type: "Ready", Status: metav1.ConditionTrue, Reason: "Valid", Message: "The spec is valid."}
+	if err := validate(&h.Spec); err != nil {
+		ready.Status, ready.Reason, ready.Message = metav1.ConditionFalse, "InvalidSpec", err.Error()
+	}
+	progressing := metav1.Condition{Type: "Progressing", Status: metav1.ConditionFalse, Reason: "NoMaintenance",
+		Message: "No nodes are under maintenance."}
+	if status.NodesUnderMaintenance > 0 {
+		progressing.Status, progressing.Reason = metav1.ConditionTrue, "MaintenanceInProgress"
+		progressing.Message = fmt.Sprintf("%d nodes are under maintenance.", status.NodesUnderMaintenance)
+	}
+	degraded := metav1.Condition{Type: "Degraded", Status: metav1.ConditionFalse, Reason: "NoFailures",
+		Message: "No drains are failing."}
+	if status.NodesFailed > 0 {
+		degraded.Status, degraded.Reason = metav1.ConditionTrue, "DrainFailed"
+		degraded.Message = fmt.Sprintf("Draining %d nodes failed.", status.NodesFailed)
+	}
+	for _, condition := range []metav1.Condition{ready, progressing, degraded} {
+		condition.ObservedGeneration = h.Generation
+		meta.SetStatusCondition(&status.Conditions, condition)
+	}
+}
+
+// validate reports whether the selectors of spec are invalid.
+func validate(spec *azurev1alpha1.NodeConditionHandlerSpec) error {
+	if spec.NodeSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(spec.NodeSelector); err != nil {
+			return fmt.Errorf("invalid nodeSelector: %w", err)
+		}
+	}
+	if spec.Drain != nil && spec.Drain.PodSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(spec.Drain.PodSelector); err != nil {
+			return fmt.Errorf("invalid drain.podSelector: %w", err)
+		}
+	}
+	return nil
+}
+
+// updateStatuses reports the maintenance status of the node in the status of every handler. A
+// nil node is removed from every handler, e.g. because it was deleted.
+func (r *NodeConditionHandlerReconciler) updateStatuses(ctx context.Context, name string, node *corev1.Node,
+	handlers []azurev1alpha1.NodeConditionHandler, p *plan, progress *drainProgress) error {
+	for n := range handlers {
+		var a *handlerAction
+		if node != nil {
+			a = p.actionOf(handlers[n].Name)
+		}
+		if err := r.updateStatus(ctx, handlers[n].Name, name, node, a, progress); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *NodeConditionHandlerReconciler) updateStatus(ctx context.Context, handler, name string, node *corev1.Node,
+	a *handlerAction, progress *drainProgress) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var h azurev1alpha1.NodeConditionHandler
+		if err := r.Get(ctx, client.ObjectKey{Name: handler}, &h); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		status := h.Status.DeepCopy()
+		var ns *azurev1alpha1.NodeMaintenanceStatus
+		if node != nil {
+			ns = maintenanceStatus(node, a, findNodeStatus(status, name), progress, metav1.Now())
+		}
+		setNodeStatus(status, name, ns)
+		summarize(&h, status)
+		if equality.Semantic.DeepEqual(status, &h.Status) {
+			return nil
+		}
+		h.Status = *status
+		return r.Status().Update(ctx, &h)
+	})
+}
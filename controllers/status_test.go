@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	azurev1alpha1 "github.com/juan-lee/nodify/api/v1alpha1"
+)
+
+func TestMaintenanceStatus(t *testing.T) {
+	now := metav1.Now()
+	earlier := metav1.NewTime(now.Add(-2 * uncordonedRetention))
+	reboot := &corev1.NodeCondition{Type: "MaintenanceScheduled", Status: corev1.ConditionTrue, Reason: "Reboot"}
+	drain := &handlerAction{Handler: "maintenance", Condition: reboot, Action: azurev1alpha1.ActionDrain}
+	cleared := &handlerAction{Handler: "maintenance", Condition: reboot, Action: azurev1alpha1.ActionUncordonOnClear}
+	scheduled := map[string]string{
+		ScheduledEventsAnnotation:   "b,a",
+		EventAnnotationPrefix + "a": `{"eventId":"a","eventType":"Reboot","eventStatus":"Scheduled","notBefore":"2021-01-02T00:00:00Z"}`,
+		EventAnnotationPrefix + "b": `{"eventId":"b","eventType":"Reboot","eventStatus":"Scheduled","notBefore":"2021-01-01T00:00:00Z"}`,
+	}
+
+	tests := map[string]struct {
+		annotations   map[string]string
+		unschedulable bool
+		action        *handlerAction
+		previous      *azurev1alpha1.NodeMaintenanceStatus
+		progress      *drainProgress
+		want          azurev1alpha1.MaintenancePhase
+	}{
+		"not handled":            {action: nil},
+		"pending":                {annotations: scheduled, action: drain, want: azurev1alpha1.PhasePending},
+		"draining":               {annotations: scheduled, action: drain, progress: &drainProgress{started: &now}, want: azurev1alpha1.PhaseDraining},
+		"drain failed":           {action: drain, progress: &drainProgress{err: errors.New("timed out")}, want: azurev1alpha1.PhaseFailed},
+		"drained":                {action: drain, progress: &drainProgress{completed: &now}, want: azurev1alpha1.PhaseDrained},
+		"cordoned":               {unschedulable: true, action: &handlerAction{Condition: reboot, Action: azurev1alpha1.ActionCordon}, want: azurev1alpha1.PhaseCordoned},
+		"never handled":          {action: cleared},
+		"uncordoned":             {action: cleared, previous: &azurev1alpha1.NodeMaintenanceStatus{Phase: azurev1alpha1.PhaseDrained}, want: azurev1alpha1.PhaseUncordoned},
+		"still cordoned":         {annotations: map[string]string{CordonedAnnotation: "a"}, unschedulable: true, action: cleared, previous: &azurev1alpha1.NodeMaintenanceStatus{Phase: azurev1alpha1.PhaseDrained}, want: azurev1alpha1.PhaseAwaitingRecovery},
+		"uncordoned a while ago": {action: cleared, previous: &azurev1alpha1.NodeMaintenanceStatus{Phase: azurev1alpha1.PhaseUncordoned, LastTransitionTime: earlier}},
+		"failure kept": {
+			action:   drain,
+			previous: &azurev1alpha1.NodeMaintenanceStatus{Phase: azurev1alpha1.PhaseFailed, LastTransitionTime: earlier},
+			want:     azurev1alpha1.PhaseFailed,
+		},
+	}
+	for name, tt := range tests {
+		node := &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node", Annotations: tt.annotations},
+			Spec:       corev1.NodeSpec{Unschedulable: tt.unschedulable},
+		}
+		got := maintenanceStatus(node, tt.action, tt.previous, tt.progress, now)
+		if got == nil {
+			if tt.want != "" {
+				t.Errorf("%s: maintenanceStatus() = nil, want %s", name, tt.want)
+			}
+			continue
+		}
+		if got.Phase != tt.want {
+			t.Errorf("%s: maintenanceStatus() = %s, want %s", name, got.Phase, tt.want)
+		}
+		if tt.previous != nil && tt.previous.Phase == got.Phase && !got.LastTransitionTime.Equal(&tt.previous.LastTransitionTime) {
+			t.Errorf("%s: LastTransitionTime = %v, want it kept", name, got.LastTransitionTime)
+		}
+	}
+
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node", Annotations: scheduled}}
+	got := maintenanceStatus(node, drain, nil, nil, now)
+	if len(got.EventIDs) != 2 || got.EventIDs[0] != "a" || got.NotBefore == nil || got.NotBefore.Day() != 1 {
+		t.Errorf("maintenanceStatus() = %+v, want events a and b, not before the earliest", got)
+	}
+}
+
+func TestUpdateStatus(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = azurev1alpha1.AddToScheme(scheme)
+	h := handlerFor("maintenance", "MaintenanceScheduled", nil, "None", "UncordonOnClear", "Reboot", "Drain")
+	h.Generation = 2
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node"},
+		Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{
+			{Type: "MaintenanceScheduled", Status: corev1.ConditionTrue, Reason: "Reboot"},
+		}},
+	}
+	r := &NodeConditionHandlerReconciler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(&h, node).Build(),
+		Log:    log.NullLogger{},
+	}
+	handlers := []azurev1alpha1.NodeConditionHandler{h}
+
+	started := metav1.NewTime(time.Now().Truncate(time.Second))
+	p := newPlan(node, handlers, log.NullLogger{})
+	if err := r.updateStatuses(ctx, node.Name, node, handlers, p, &drainProgress{started: &started, podsRemaining: 3}); err != nil {
+		t.Fatal(err)
+	}
+	got := &azurev1alpha1.NodeConditionHandler{}
+	if err := r.Get(ctx, client.ObjectKey{Name: h.Name}, got); err != nil {
+		t.Fatal(err)
+	}
+	status := got.Status
+	if len(status.Nodes) != 1 || status.Nodes[0].Phase != azurev1alpha1.PhaseDraining || status.Nodes[0].PodsRemaining != 3 {
+		t.Fatalf("status.nodes = %+v, want the node draining 3 pods", status.Nodes)
+	}
+	if status.NodesUnderMaintenance != 1 || status.NodesDraining != 1 || status.ObservedGeneration != 2 {
+		t.Errorf("status = %+v, want one node under maintenance and draining", status)
+	}
+	if !meta.IsStatusConditionTrue(status.Conditions, "Ready") || !meta.IsStatusConditionTrue(status.Conditions, "Progressing") ||
+		!meta.IsStatusConditionFalse(status.Conditions, "Degraded") {
+		t.Errorf("status.conditions = %+v, want Ready and Progressing", status.Conditions)
+	}
+
+	if err := r.updateStatuses(ctx, node.Name, nil, handlers, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	got = &azurev1alpha1.NodeConditionHandler{}
+	if err := r.Get(ctx, client.ObjectKey{Name: h.Name}, got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Status.Nodes) != 0 || got.Status.NodesUnderMaintenance != 0 ||
+		!meta.IsStatusConditionFalse(got.Status.Conditions, "Progressing") {
+		t.Errorf("status = %+v, want the deleted node removed", got.Status)
+	}
+}
@@ -0,0 +1,179 @@
+package ack
+
+import (
+	"testing"
+	"time"
+
+	"daemon/metadata"
+)
+
+func TestParsePolicy(t *testing.T) {
+	p, err := ParsePolicy("Freeze=Immediate, Reboot/User=Delay:5m,Preempt=Never,*=AfterDrain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Rule{
+		{EventType: "Freeze", Action: Immediate},
+		{EventType: "Reboot", EventSource: "User", Action: Delay, Delay: 5 * time.Minute},
+		{EventType: "Preempt", Action: Never},
+		{Action: AfterDrain},
+	}
+	if len(p.Rules) != len(want) {
+		t.Fatalf("Rules = %+v, want %+v", p.Rules, want)
+	}
+	for n := range want {
+		if p.Rules[n] != want[n] {
+			t.Errorf("Rules[%d] = %+v, want %+v", n, p.Rules[n], want[n])
+		}
+	}
+	if got, err := ParsePolicy(p.String()); err != nil || got.String() != p.String() {
+		t.Errorf("ParsePolicy(%q) = %v, %v, want a round trip", p.String(), got, err)
+	}
+
+	for _, invalid := range []string{"Freeze", "Freeze=Later", "Reboot=Delay", "Reboot=Delay:soon", "Freeze=Immediate:5m"} {
+		if _, err := ParsePolicy(invalid); err == nil {
+			t.Errorf("ParsePolicy(%q) succeeded, want error", invalid)
+		}
+	}
+}
+
+func TestPolicyFor(t *testing.T) {
+	p, err := ParsePolicy("Reboot/User=Immediate,Reboot=Never")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		event metadata.Event
+		want  Action
+	}{
+		{metadata.Event{EventType: "Reboot", EventSource: "User"}, Immediate},
+		{metadata.Event{EventType: "Reboot", EventSource: "Platform"}, Never},
+		{metadata.Event{EventType: "Redeploy", EventSource: "Platform"}, AfterDrain},
+	}
+	for _, tt := range tests {
+		if got := p.For(&tt.event).Action; got != tt.want {
+			t.Errorf("For(%s/%s) = %s, want %s", tt.event.EventType, tt.event.EventSource, got, tt.want)
+		}
+	}
+}
+
+func TestTrackerDue(t *testing.T) {
+	p, err := ParsePolicy("Freeze=Immediate,Redeploy=Delay:5m,Preempt=Never,*=AfterDrain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2021, 3, 30, 13, 0, 0, 0, time.UTC)
+	notBefore := metadata.TimeRFC1123{Time: start.Add(15 * time.Minute)}
+	events := []metadata.Event{
+		{EventID: "freeze", EventType: "Freeze", NotBefore: notBefore},
+		{EventID: "redeploy", EventType: "Redeploy", NotBefore: notBefore},
+		{EventID: "preempt", EventType: "Preempt", NotBefore: notBefore},
+		{EventID: "reboot", EventType: "Reboot", NotBefore: notBefore},
+	}
+	tracker := NewTracker(p)
+
+	steps := []struct {
+		at      time.Duration
+		drained map[string]bool
+		want    []string
+	}{
+		{at: 0, want: []string{"freeze"}},
+		{at: time.Minute},
+		{at: 5 * time.Minute, want: []string{"redeploy"}},
+		{at: 6 * time.Minute, drained: map[string]bool{"reboot": true}, want: []string{"reboot"}},
+		{at: 14 * time.Minute},
+	}
+	for _, step := range steps {
+		now := start.Add(step.at)
+		tracker.Observe(events, now)
+		due := tracker.Due(events, step.drained, now)
+		if len(due) != len(step.want) {
+			t.Fatalf("T+%v: Due() = %+v, want %v", step.at, due, step.want)
+		}
+		for n := range due {
+			if due[n].Event.EventID != step.want[n] || due[n].Forced {
+				t.Errorf("T+%v: Due()[%d] = %+v, want unforced %s", step.at, n, due[n], step.want[n])
+			}
+			tracker.MarkAcked(due[n].Event.EventID)
+		}
+	}
+}
+
+func TestTrackerForcedBeforeNotBefore(t *testing.T) {
+	start := time.Date(2021, 3, 30, 13, 0, 0, 0, time.UTC)
+	events := []metadata.Event{
+		{EventID: "reboot", EventType: "Reboot", NotBefore: metadata.TimeRFC1123{Time: start.Add(10 * time.Minute)}},
+	}
+	tracker := NewTracker(DefaultPolicy())
+	tracker.Observe(events, start)
+	if due := tracker.Due(events, nil, start.Add(8*time.Minute)); len(due) != 0 {
+		t.Errorf("Due() = %+v before the deadline, want none", due)
+	}
+	due := tracker.Due(events, nil, start.Add(9*time.Minute))
+	if len(due) != 1 || !due[0].Forced {
+		t.Fatalf("Due() = %+v at the deadline, want a forced ack", due)
+	}
+}
+
+func TestTrackerStartedEvent(t *testing.T) {
+	start := time.Date(2021, 3, 30, 13, 0, 0, 0, time.UTC)
+	events := []metadata.Event{{EventID: "reboot", EventType: "Reboot", EventStatus: "Started"}}
+	tracker := NewTracker(DefaultPolicy())
+	tracker.Observe(events, start)
+	if due := tracker.Due(events, nil, start.Add(time.Hour)); len(due) != 0 {
+		t.Errorf("Due() = %+v for a Started event without NotBefore, want none", due)
+	}
+	if due := tracker.Due(events, map[string]bool{"reboot": true}, start.Add(time.Hour)); len(due) != 1 || due[0].Forced {
+		t.Errorf("Due() = %+v once drained, want the event acknowledged by its rule", due)
+	}
+}
+
+func TestTrackerNewIncarnation(t *testing.T) {
+	start := time.Date(2021, 3, 30, 13, 0, 0, 0, time.UTC)
+	tracker := NewTracker(DefaultPolicy())
+	first := []metadata.Event{{EventID: "a", EventType: "Freeze"}}
+	tracker.Observe(first, start)
+	for _, due := range tracker.Due(first, nil, start) {
+		tracker.MarkAcked(due.Event.EventID)
+	}
+
+	second := []metadata.Event{first[0], {EventID: "b", EventType: "Freeze"}}
+	tracker.Observe(second, start.Add(time.Minute))
+	due := tracker.Due(second, nil, start.Add(time.Minute))
+	if len(due) != 1 || due[0].Event.EventID != "b" {
+		t.Errorf("Due() = %+v, want only the new event b", due)
+	}
+	if seen, _ := tracker.FirstSeen("a"); !seen.Equal(start) {
+		t.Errorf("FirstSeen(a) = %v, want %v", seen, start)
+	}
+
+	tracker.Observe(nil, start.Add(2*time.Minute))
+	if tracker.Acked("a") {
+		t.Error("Acked(a) = true after the event was cleared")
+	}
+}
+
+func TestTrackerRestore(t *testing.T) {
+	start := time.Date(2021, 3, 30, 13, 0, 0, 0, time.UTC)
+	events := []metadata.Event{
+		{EventID: "a", EventType: "Freeze"},
+		{EventID: "b", EventType: "Reboot"},
+	}
+	tracker := NewTracker(DefaultPolicy())
+	tracker.Observe(events, start)
+	tracker.MarkAcked("a")
+	tracker.MarkAckedBeforeDrained("b")
+
+	restarted := NewTracker(DefaultPolicy())
+	restarted.Restore(tracker.State())
+	restarted.Observe(events, start.Add(time.Hour))
+	if !restarted.Acked("a") || restarted.AckedBeforeDrained("a") || !restarted.AckedBeforeDrained("b") {
+		t.Errorf("State() = %+v, want a acked and b acked before drained", restarted.State())
+	}
+	if seen, _ := restarted.FirstSeen("b"); !seen.Equal(start) {
+		t.Errorf("FirstSeen(b) = %v, want %v", seen, start)
+	}
+	if due := restarted.Due(events, nil, start.Add(time.Hour)); len(due) != 0 {
+		t.Errorf("Due() = %+v, want none after restore", due)
+	}
+}
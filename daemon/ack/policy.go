@@ -0,0 +1,153 @@
+// Package ack decides when the daemon acknowledges scheduled events.
+package ack
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"daemon/metadata"
+)
+
+// Action is what the daemon does with a scheduled event.
+type Action string
+
+const (
+	// Immediate acknowledges the event as soon as it is seen.
+	Immediate Action = "Immediate"
+	// Delay acknowledges the event a fixed time after it is first seen.
+	Delay Action = "Delay"
+	// AfterDrain acknowledges the event once the controller has drained the node for it.
+	AfterDrain Action = "AfterDrain"
+	// Never leaves the event alone and lets NotBefore expire.
+	Never Action = "Never"
+)
+
+// DefaultDeadline is how long before NotBefore an event is acknowledged even if its rule is not satisfied yet.
+const DefaultDeadline = time.Minute
+
+// Rule selects an Action for events matching EventType and EventSource. An empty
+// EventType or EventSource matches any value.
+type Rule struct {
+	EventType   string
+	EventSource string
+	Action      Action
+	// Delay is the time to wait before acknowledging events with the Delay action.
+	Delay time.Duration
+}
+
+func (r Rule) matches(event *metadata.Event) bool {
+	return (r.EventType == "" || strings.EqualFold(r.EventType, event.EventType)) &&
+		(r.EventSource == "" || strings.EqualFold(r.EventSource, event.EventSource))
+}
+
+func (r Rule) String() string {
+	selector := "*"
+	if r.EventType != "" {
+		selector = r.EventType
+	}
+	if r.EventSource != "" {
+		selector += "/" + r.EventSource
+	}
+	action := string(r.Action)
+	if r.Action == Delay {
+		action += ":" + r.Delay.String()
+	}
+	return selector + "=" + action
+}
+
+// Policy is an ordered list of Rules. The first matching rule applies, and events matching
+// none are acknowledged after the node is drained.
+type Policy struct {
+	Rules []Rule
+	// Deadline is how long before NotBefore an event is acknowledged regardless of its rule,
+	// unless the rule is Never.
+	Deadline time.Duration
+}
+
+// DefaultPolicy acknowledges Freeze events immediately, since there is nothing to drain for
+// a pause of a few seconds, and everything else after the node is drained.
+func DefaultPolicy() Policy {
+	return Policy{
+		Rules: []Rule{
+			{EventType: "Freeze", Action: Immediate},
+			{Action: AfterDrain},
+		},
+		Deadline: DefaultDeadline,
+	}
+}
+
+// For returns the Rule that applies to event.
+func (p Policy) For(event *metadata.Event) Rule {
+	for _, r := range p.Rules {
+		if r.matches(event) {
+			return r
+		}
+	}
+	return Rule{Action: AfterDrain}
+}
+
+func (p Policy) String() string {
+	rules := make([]string, 0, len(p.Rules))
+	for _, r := range p.Rules {
+		rules = append(rules, r.String())
+	}
+	return strings.Join(rules, ",")
+}
+
+// ParsePolicy parses a comma separated list of rules of the form
+// EventType[/EventSource]=Action[:Delay], where * matches any EventType, e.g.
+//
+//	Freeze=Immediate,Reboot/User=Delay:5m,Preempt=Never,*=AfterDrain
+func ParsePolicy(value string) (Policy, error) {
+	p := Policy{Deadline: DefaultDeadline}
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		r, err := parseRule(field)
+		if err != nil {
+			return Policy{}, err
+		}
+		p.Rules = append(p.Rules, r)
+	}
+	return p, nil
+}
+
+func parseRule(value string) (Rule, error) {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return Rule{}, fmt.Errorf("invalid ack rule %q: expected EventType[/EventSource]=Action", value)
+	}
+	r := Rule{}
+	selector := strings.SplitN(parts[0], "/", 2)
+	if selector[0] != "*" {
+		r.EventType = selector[0]
+	}
+	if len(selector) == 2 {
+		r.EventSource = selector[1]
+	}
+	action := strings.SplitN(parts[1], ":", 2)
+	switch Action(action[0]) {
+	case Immediate, AfterDrain, Never:
+		if len(action) == 2 {
+			return Rule{}, fmt.Errorf("invalid ack rule %q: only %s takes a duration", value, Delay)
+		}
+		r.Action = Action(action[0])
+	case Delay:
+		if len(action) != 2 {
+			return Rule{}, fmt.Errorf("invalid ack rule %q: %s requires a duration, e.g. %s:5m", value, Delay, Delay)
+		}
+		d, err := time.ParseDuration(action[1])
+		if err != nil || d < 0 {
+			return Rule{}, fmt.Errorf("invalid ack rule %q: bad duration %q", value, action[1])
+		}
+		r.Action = Delay
+		r.Delay = d
+	default:
+		return Rule{}, fmt.Errorf("invalid ack rule %q: unknown action %q, expected one of %s, %s, %s or %s",
+			value, action[0], Immediate, Delay, AfterDrain, Never)
+	}
+	return r, nil
+}
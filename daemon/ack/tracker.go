@@ -0,0 +1,159 @@
+package ack
+
+import (
+	"time"
+
+	"daemon/metadata"
+)
+
+// Decision is an event that is due to be acknowledged.
+type Decision struct {
+	Event *metadata.Event
+	Rule  Rule
+	// Forced is set when the event is acknowledged only because NotBefore is about to pass.
+	Forced bool
+}
+
+// EventState is what a Tracker remembers about an event.
+type EventState struct {
+	FirstSeen time.Time `json:"firstSeen"`
+	Acked     bool      `json:"acked,omitempty"`
+	// AckedBeforeDrained is set if the event was acknowledged before the node finished draining.
+	AckedBeforeDrained bool `json:"ackedBeforeDrained,omitempty"`
+}
+
+// Tracker remembers, per EventId, when events were first seen and whether they were
+// acknowledged, so a new DocumentIncarnation neither re-acknowledges old events nor skips new ones.
+type Tracker struct {
+	Policy        Policy
+	firstSeen     map[string]time.Time
+	acked         map[string]bool
+	beforeDrained map[string]bool
+}
+
+// NewTracker returns a Tracker applying policy.
+func NewTracker(policy Policy) *Tracker {
+	return &Tracker{
+		Policy:        policy,
+		firstSeen:     map[string]time.Time{},
+		acked:         map[string]bool{},
+		beforeDrained: map[string]bool{},
+	}
+}
+
+// State returns what the Tracker remembers about each event, keyed by EventId.
+func (t *Tracker) State() map[string]EventState {
+	state := make(map[string]EventState, len(t.firstSeen))
+	for id, seen := range t.firstSeen {
+		state[id] = EventState{FirstSeen: seen, Acked: t.acked[id], AckedBeforeDrained: t.beforeDrained[id]}
+	}
+	return state
+}
+
+// Restore replaces what the Tracker remembers with state, e.g. after the daemon restarts.
+func (t *Tracker) Restore(state map[string]EventState) {
+	t.firstSeen = make(map[string]time.Time, len(state))
+	t.acked = map[string]bool{}
+	t.beforeDrained = map[string]bool{}
+	for id, es := range state {
+		t.firstSeen[id] = es.FirstSeen
+		if es.Acked {
+			t.acked[id] = true
+		}
+		if es.AckedBeforeDrained {
+			t.beforeDrained[id] = true
+		}
+	}
+}
+
+// Observe records the events currently scheduled at now and forgets events that are gone.
+func (t *Tracker) Observe(events []metadata.Event, now time.Time) {
+	current := map[string]bool{}
+	for n := range events {
+		id := events[n].EventID
+		current[id] = true
+		if _, ok := t.firstSeen[id]; !ok {
+			t.firstSeen[id] = now
+		}
+	}
+	for id := range t.firstSeen {
+		if !current[id] {
+			delete(t.firstSeen, id)
+			delete(t.acked, id)
+			delete(t.beforeDrained, id)
+		}
+	}
+}
+
+// FirstSeen returns when the event with id was first observed.
+func (t *Tracker) FirstSeen(id string) (time.Time, bool) {
+	seen, ok := t.firstSeen[id]
+	return seen, ok
+}
+
+// Acked reports whether the event with id has been acknowledged.
+func (t *Tracker) Acked(id string) bool {
+	return t.acked[id]
+}
+
+// MarkAcked records that the event with id has been acknowledged.
+func (t *Tracker) MarkAcked(id string) {
+	t.acked[id] = true
+}
+
+// MarkAckedBeforeDrained records that the event with id has been acknowledged before the node
+// finished draining for it.
+func (t *Tracker) MarkAckedBeforeDrained(id string) {
+	t.acked[id] = true
+	t.beforeDrained[id] = true
+}
+
+// AckedBeforeDrained reports whether the event with id was acknowledged before the node finished
+// draining for it.
+func (t *Tracker) AckedBeforeDrained(id string) bool {
+	return t.beforeDrained[id]
+}
+
+// WaitingOnDrain reports whether any unacknowledged event waits for the node to be drained.
+func (t *Tracker) WaitingOnDrain(events []metadata.Event) bool {
+	for n := range events {
+		if !t.acked[events[n].EventID] && t.Policy.For(&events[n]).Action == AfterDrain {
+			return true
+		}
+	}
+	return false
+}
+
+// Due returns the observed events that should be acknowledged at now. drained holds the
+// EventIds the controller has finished draining the node for.
+func (t *Tracker) Due(events []metadata.Event, drained map[string]bool, now time.Time) []Decision {
+	var due []Decision
+	for n := range events {
+		event := &events[n]
+		if t.acked[event.EventID] {
+			continue
+		}
+		firstSeen, ok := t.firstSeen[event.EventID]
+		if !ok {
+			continue
+		}
+		rule := t.Policy.For(event)
+		ready := false
+		switch rule.Action {
+		case Immediate:
+			ready = true
+		case Delay:
+			ready = !now.Before(firstSeen.Add(rule.Delay))
+		case AfterDrain:
+			ready = drained[event.EventID]
+		case Never:
+			continue
+		}
+		// Started events have no NotBefore, so there is no deadline to force their ack.
+		forced := !ready && !event.NotBefore.IsZero() && !now.Before(event.NotBefore.Add(-t.Policy.Deadline))
+		if ready || forced {
+			due = append(due, Decision{Event: event, Rule: rule, Forced: forced})
+		}
+	}
+	return due
+}
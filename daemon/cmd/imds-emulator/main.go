@@ -0,0 +1,91 @@
+// Command imds-emulator serves the Azure Instance Metadata Service instance and scheduled events
+// endpoints from a scenario file so the nodify daemon can rehearse maintenance outside of Azure.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"daemon/metadata/fake"
+)
+
+func main() {
+	var addr string
+	var scenarioPath string
+	var name string
+	flag.StringVar(&addr, "addr", "127.0.0.1:8169", "The address the emulator listens on.")
+	flag.StringVar(&scenarioPath, "scenario", "", "Path to a YAML or JSON scenario file.")
+	flag.StringVar(&name, "name", "", "The compute name to report, overrides the scenario name. Defaults to the hostname.")
+	flag.Parse()
+
+	if scenarioPath == "" {
+		log.Fatal("-scenario is required")
+	}
+	scenario, err := loadScenario(scenarioPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if name != "" {
+		scenario.Name = name
+	}
+	if scenario.Name == "" {
+		if scenario.Name, err = os.Hostname(); err != nil {
+			log.Fatalf("cannot determine compute name: %v", err)
+		}
+	}
+
+	imds := fake.NewIMDS(scenario.Name)
+	imds.OnAck = func(eventID string) {
+		log.Printf("ack: EventId=%s", eventID)
+	}
+	srv := &http.Server{Addr: addr, Handler: logRequests(imds)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		cancel()
+	}()
+	go play(ctx, scenario, imds)
+	go func() {
+		<-ctx.Done()
+		_ = srv.Shutdown(context.Background())
+	}()
+
+	log.Printf("serving %s for %q on http://%s", scenarioPath, scenario.Name, addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
+
+// play applies each step of the scenario to imds once its time has come.
+func play(ctx context.Context, scenario *Scenario, imds *fake.IMDS) {
+	start := time.Now()
+	for n := range scenario.Steps {
+		timer := time.NewTimer(time.Until(start.Add(scenario.Steps[n].After.Duration)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		incarnation := imds.SetEvents(scenario.events(n, start)...)
+		log.Printf("step %d: DocumentIncarnation=%d events=%+v", n, incarnation, imds.Events().Events)
+	}
+	log.Printf("scenario complete")
+}
+
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("%s %s", r.Method, r.URL)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"daemon/metadata"
+)
+
+// Scenario is a timeline of scheduled events documents served by the emulator.
+type Scenario struct {
+	// Name is the compute name reported by the instance endpoint. Events without
+	// Resources are scheduled for this virtual machine.
+	Name string `json:"name,omitempty"`
+	// Steps are applied in order, each one replacing the scheduled events document
+	// and incrementing its DocumentIncarnation.
+	Steps []Step `json:"steps"`
+}
+
+// Step replaces the scheduled events document After the emulator started.
+type Step struct {
+	After  metav1.Duration `json:"after"`
+	Events []ScenarioEvent `json:"events,omitempty"`
+}
+
+// ScenarioEvent is a metadata.Event whose NotBefore may be given relative to the start of the scenario.
+type ScenarioEvent struct {
+	metadata.Event
+	// NotBeforeAfter sets NotBefore to this long after the emulator started.
+	NotBeforeAfter *metav1.Duration `json:"NotBeforeAfter,omitempty"`
+}
+
+func loadScenario(path string) (*Scenario, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := Scenario{}
+	if err := yaml.UnmarshalStrict(data, &s); err != nil {
+		return nil, fmt.Errorf("cannot parse scenario %s: %w", path, err)
+	}
+	if err := s.validate(); err != nil {
+		return nil, fmt.Errorf("invalid scenario %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+func (s *Scenario) validate() error {
+	if len(s.Steps) == 0 {
+		return errors.New("at least one step is required")
+	}
+	for n := range s.Steps {
+		if n > 0 && s.Steps[n].After.Duration < s.Steps[n-1].After.Duration {
+			return fmt.Errorf("steps[%d].after must not be before steps[%d].after", n, n-1)
+		}
+		for m := range s.Steps[n].Events {
+			if s.Steps[n].Events[m].EventID == "" {
+				return fmt.Errorf("steps[%d].events[%d].EventId is required", n, m)
+			}
+		}
+	}
+	return nil
+}
+
+// events returns the metadata.Events of step n with relative times resolved against start.
+func (s *Scenario) events(n int, start time.Time) []metadata.Event {
+	events := make([]metadata.Event, 0, len(s.Steps[n].Events))
+	for _, se := range s.Steps[n].Events {
+		event := se.Event
+		if se.NotBeforeAfter != nil {
+			event.NotBefore = metadata.TimeRFC1123{Time: start.Add(se.NotBeforeAfter.Duration)}
+		}
+		events = append(events, event)
+	}
+	return events
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadScenarios(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("scenarios", "*.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no scenarios found")
+	}
+	for _, path := range paths {
+		if _, err := loadScenario(path); err != nil {
+			t.Errorf("loadScenario(%q) = %v", path, err)
+		}
+	}
+}
+
+func TestScenarioEvents(t *testing.T) {
+	s, err := loadScenario(filepath.Join("scenarios", "reboot.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2021, 3, 30, 13, 0, 0, 0, time.UTC)
+	events := s.events(0, start)
+	if len(events) != 1 {
+		t.Fatalf("events(0) = %+v, want 1 event", events)
+	}
+	if want := start.Add(15 * time.Minute); !events[0].NotBefore.Equal(want) {
+		t.Errorf("NotBefore = %v, want %v", events[0].NotBefore, want)
+	}
+	if events[0].EventType != "Reboot" || events[0].EventStatus != "Scheduled" {
+		t.Errorf("events[0] = %+v, want a scheduled Reboot", events[0])
+	}
+	if events := s.events(2, start); len(events) != 0 {
+		t.Errorf("events(2) = %+v, want none", events)
+	}
+}
+
+func TestLoadScenarioRejectsInvalid(t *testing.T) {
+	tests := map[string]string{
+		"no steps":      "name: vm\n",
+		"unknown field": "steps:\n- after: 0s\n  bogus: true\n",
+		"out of order":  "steps:\n- after: 5m\n- after: 1m\n",
+		"missing id":    "steps:\n- after: 0s\n  events:\n  - EventType: Reboot\n",
+	}
+	dir, err := ioutil.TempDir("", "scenario")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, scenario := range tests {
+		path := filepath.Join(dir, "scenario.yaml")
+		if err := ioutil.WriteFile(path, []byte(scenario), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadScenario(path); err == nil {
+			t.Errorf("%s: loadScenario() succeeded, want error", name)
+		}
+	}
+}
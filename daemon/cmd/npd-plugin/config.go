@@ -0,0 +1,90 @@
+package main
+
+import (
+	"time"
+
+	"daemon/watcher"
+)
+
+const (
+	// defaultPluginPath is where the plugin is expected in the node-problem-detector container.
+	defaultPluginPath = "/config/plugin/nodify-npd-plugin"
+	// invokeInterval is how often node-problem-detector runs the plugin for each rule, matching
+	// how often the daemon polls the metadata service.
+	invokeInterval = 30 * time.Second
+	// pluginTimeout bounds a single run of the plugin, including falling back to older
+	// api-versions of the scheduled events endpoint.
+	pluginTimeout = 25 * time.Second
+	// checkTimeout leaves the plugin time to report Unknown before node-problem-detector kills it.
+	checkTimeout = 20 * time.Second
+	// maxOutputLength truncates the condition message, long enough for Describe's output.
+	maxOutputLength = 512
+)
+
+// config is a node-problem-detector custom plugin monitor config.
+type config struct {
+	Plugin            string             `json:"plugin"`
+	PluginConfig      pluginConfig       `json:"pluginConfig"`
+	Source            string             `json:"source"`
+	MetricsReporting  bool               `json:"metricsReporting"`
+	DefaultConditions []defaultCondition `json:"conditions"`
+	Rules             []rule             `json:"rules"`
+}
+
+type pluginConfig struct {
+	InvokeInterval  string `json:"invoke_interval"`
+	Timeout         string `json:"timeout"`
+	MaxOutputLength int    `json:"max_output_length"`
+	Concurrency     int    `json:"concurrency"`
+}
+
+type defaultCondition struct {
+	Type    string `json:"type"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+type rule struct {
+	Type      string   `json:"type"`
+	Condition string   `json:"condition"`
+	Reason    string   `json:"reason"`
+	Path      string   `json:"path"`
+	Args      []string `json:"args"`
+	Timeout   string   `json:"timeout"`
+}
+
+// newConfig returns the config running the plugin at path once per EventType, each rule reporting
+// its own condition named after conditionType as the daemon does with the PerEventTypeCondition
+// layout. The other layouts can't be expressed: node-problem-detector resets a condition to its
+// default as soon as any rule of the condition reports OK, so rules sharing a single condition
+// would clear it on every run.
+func newConfig(path, conditionType string) *config {
+	c := &config{
+		Plugin: "custom",
+		PluginConfig: pluginConfig{
+			InvokeInterval:  invokeInterval.String(),
+			Timeout:         pluginTimeout.String(),
+			MaxOutputLength: maxOutputLength,
+			Concurrency:     1,
+		},
+		Source:           "nodify-npd-plugin",
+		MetricsReporting: true,
+	}
+	for _, eventType := range watcher.EventTypes {
+		eventTypeCondition := watcher.EventTypeCondition(conditionType, eventType)
+		c.DefaultConditions = append(c.DefaultConditions, defaultCondition{
+			Type:    eventTypeCondition,
+			Reason:  "None",
+			Message: "No maintenance scheduled.",
+		})
+		c.Rules = append(c.Rules, rule{
+			Type:      "permanent",
+			Condition: eventTypeCondition,
+			Reason:    eventType,
+			Path:      path,
+			Args:      []string{"-event-type=" + eventType},
+			Timeout:   pluginTimeout.String(),
+		})
+	}
+	return c
+}
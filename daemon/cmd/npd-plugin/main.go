@@ -0,0 +1,66 @@
+// Command npd-plugin checks the scheduled events of the virtual machine as a node-problem-detector
+// custom plugin, so clusters already running node-problem-detector report the same conditions as
+// the nodify daemon without running it. Scheduled events are not acknowledged in this mode, so
+// maintenance starts at NotBefore.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"daemon/metadata"
+	"daemon/watcher"
+)
+
+func main() {
+	var imdsEndpoint string
+	var imdsTimeout time.Duration
+	var scheduledEventsAPIVersion string
+	var eventType string
+	var conditionType string
+	var printConfig bool
+	var pluginPath string
+	flag.StringVar(&imdsEndpoint, "imds-endpoint", metadata.DefaultEndpoint, "The base URL of the Azure Instance Metadata Service.")
+	flag.DurationVar(&imdsTimeout, "imds-timeout", metadata.DefaultTimeout,
+		"The timeout for each request to the Azure Instance Metadata Service.")
+	flag.StringVar(&scheduledEventsAPIVersion, "imds-scheduled-events-api-version", metadata.DefaultScheduledEventsAPIVersion,
+		"The api-version of the scheduled events endpoint. Older versions are tried if the metadata service rejects it.")
+	flag.StringVar(&eventType, "event-type", "", "The EventType to check for, e.g. Reboot.")
+	flag.StringVar(&conditionType, "condition-type", watcher.DefaultConditionType,
+		"The node condition type the conditions of each EventType are named after, see the daemon's -condition-type. Used by -print-config.")
+	flag.BoolVar(&printConfig, "print-config", false, "Print the custom plugin monitor config for node-problem-detector and exit.")
+	flag.StringVar(&pluginPath, "plugin-path", defaultPluginPath, "The path node-problem-detector runs the plugin from, used by -print-config.")
+	flag.Parse()
+
+	if err := watcher.ValidateConditionType(conditionType); err != nil {
+		log.Fatalf("invalid -condition-type: %v\n", err)
+	}
+	if printConfig {
+		out, err := json.MarshalIndent(newConfig(pluginPath, conditionType), "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(out))
+		return
+	}
+	if eventType == "" {
+		log.Fatal("-event-type is required")
+	}
+
+	client := metadata.NewClient(
+		metadata.WithEndpoint(imdsEndpoint),
+		metadata.WithTimeout(imdsTimeout),
+		metadata.WithScheduledEventsAPIVersion(scheduledEventsAPIVersion),
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+	status, message := check(ctx, client, eventType)
+	cancel()
+	fmt.Println(message)
+	os.Exit(int(status))
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"daemon/metadata"
+	"daemon/watcher"
+)
+
+// Status is the exit code of a node-problem-detector custom plugin.
+type Status int
+
+const (
+	// OK means the condition is false.
+	OK Status = 0
+	// NonOK means the condition is true, with the reason of the rule that ran the plugin.
+	NonOK Status = 1
+	// Unknown means the plugin could not tell.
+	Unknown Status = 2
+)
+
+// Source provides the scheduled events of the virtual machine, e.g. a *metadata.Client.
+type Source interface {
+	Scheduled(ctx context.Context) (*metadata.ScheduledEvents, error)
+}
+
+// check reports NonOK if an event of eventType is scheduled.
+func check(ctx context.Context, source Source, eventType string) (Status, string) {
+	se, err := source.Scheduled(ctx)
+	if err != nil {
+		return Unknown, fmt.Sprintf("Failed to get scheduled events: %v", err)
+	}
+	var events []metadata.Event
+	for n := range se.Events {
+		if se.Events[n].EventType == eventType {
+			events = append(events, se.Events[n])
+		}
+	}
+	if len(events) == 0 {
+		return OK, fmt.Sprintf("No %s scheduled.", eventType)
+	}
+	message := watcher.Describe(watcher.MostUrgent(events))
+	if others := len(se.Events) - 1; others > 0 {
+		message += fmt.Sprintf(" %d other events are scheduled.", others)
+	}
+	return NonOK, message
+}
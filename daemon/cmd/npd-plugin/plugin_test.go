@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"daemon/metadata"
+	"daemon/watcher"
+)
+
+type source struct {
+	se  *metadata.ScheduledEvents
+	err error
+}
+
+func (s *source) Scheduled(_ context.Context) (*metadata.ScheduledEvents, error) {
+	return s.se, s.err
+}
+
+func TestCheck(t *testing.T) {
+	notBefore := metadata.TimeRFC1123{Time: time.Date(2021, 3, 30, 13, 0, 0, 0, time.UTC)}
+	scheduled := &source{se: &metadata.ScheduledEvents{
+		Events: []metadata.Event{
+			{EventID: "a", EventType: "Freeze", EventStatus: "Scheduled", NotBefore: notBefore},
+			{EventID: "b", EventType: "Reboot", EventStatus: "Scheduled", NotBefore: notBefore},
+		},
+	}}
+	tests := []struct {
+		name      string
+		source    Source
+		eventType string
+		want      Status
+		message   string
+	}{
+		{"most urgent", scheduled, "Reboot", NonOK, "Reboot b is Scheduled"},
+		{"less urgent", scheduled, "Freeze", NonOK, "Freeze a is Scheduled"},
+		{"none", scheduled, "Redeploy", OK, "No Redeploy"},
+		{"unavailable", &source{err: errors.New("boom")}, "Reboot", Unknown, "boom"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, message := check(context.Background(), tt.source, tt.eventType)
+			if got != tt.want || !strings.Contains(message, tt.message) {
+				t.Errorf("check() = %v, %q, want %v and a message containing %q", got, message, tt.want, tt.message)
+			}
+		})
+	}
+}
+
+func TestNewConfig(t *testing.T) {
+	c := newConfig(defaultPluginPath, watcher.DefaultConditionType)
+	if got, want := len(c.DefaultConditions), len(watcher.EventTypes); got != want {
+		t.Errorf("len(conditions) = %d, want %d", got, want)
+	}
+	if got, want := len(c.Rules), len(watcher.EventTypes); got != want {
+		t.Fatalf("len(rules) = %d, want %d", got, want)
+	}
+	if c := newConfig(defaultPluginPath, "MaintenancePlanned"); c.Rules[1].Condition != "RebootPlanned" {
+		t.Errorf("rules[1] = %+v, want the RebootPlanned condition", c.Rules[1])
+	}
+	conditions := map[string]bool{}
+	for _, r := range c.Rules {
+		if r.Condition != r.Reason+"Scheduled" {
+			t.Errorf("rule %+v reports %s in the wrong condition", r, r.Reason)
+		}
+		if conditions[r.Condition] {
+			t.Errorf("several rules report %s, node-problem-detector would reset it", r.Condition)
+		}
+		conditions[r.Condition] = true
+	}
+}
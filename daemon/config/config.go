@@ -0,0 +1,290 @@
+// Package config loads the daemon's configuration from a versioned file, typically mounted
+// from a ConfigMap, with command line flags overriding the values in the file.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/yaml"
+
+	"daemon/ack"
+	"daemon/facts"
+	"daemon/metadata"
+	"daemon/node"
+	"daemon/watcher"
+)
+
+const (
+	// APIVersion is the only version of the configuration file understood by the daemon.
+	APIVersion = "nodify.azure.microsoft.com/v1alpha1"
+	// Kind identifies a configuration file of the daemon.
+	Kind = "DaemonConfig"
+)
+
+// DaemonConfig is the configuration file of the daemon.
+type DaemonConfig struct {
+	metav1.TypeMeta `json:",inline"`
+	IMDS            IMDS `json:"imds"`
+	// Interval is how often scheduled events are polled.
+	Interval      metav1.Duration `json:"interval"`
+	Ack           Ack             `json:"ack"`
+	Conditions    Conditions      `json:"conditions"`
+	InstanceFacts InstanceFacts   `json:"instanceFacts"`
+	Metrics       Metrics         `json:"metrics"`
+	Health        Health          `json:"health"`
+}
+
+// IMDS configures the client of the Azure Instance Metadata Service.
+type IMDS struct {
+	Endpoint string          `json:"endpoint"`
+	Timeout  metav1.Duration `json:"timeout"`
+	// ScheduledEventsAPIVersion is the api-version tried first, older ones are tried if the
+	// metadata service rejects it.
+	ScheduledEventsAPIVersion string `json:"scheduledEventsAPIVersion"`
+}
+
+// Ack configures when scheduled events are acknowledged, see ack.Policy.
+type Ack struct {
+	// Policy are the rules of the ack.Policy, see ack.ParsePolicy.
+	Policy   []string        `json:"policy"`
+	Deadline metav1.Duration `json:"deadline"`
+}
+
+// Conditions configures how scheduled events are reported on the node.
+type Conditions struct {
+	// Type names the condition reporting the most urgent event, and the conditions reporting
+	// each EventType, see watcher.EventTypeCondition.
+	Type   string `json:"type"`
+	Layout string `json:"layout"`
+	// EventSource is the component recorded as the source of Kubernetes events.
+	EventSource string `json:"eventSource"`
+}
+
+// InstanceFacts configures the facts published on the node, see facts.Allowlist.
+type InstanceFacts struct {
+	Allow           []string        `json:"allow,omitempty"`
+	RefreshInterval metav1.Duration `json:"refreshInterval"`
+}
+
+// Metrics configures the metrics endpoint.
+type Metrics struct {
+	BindAddress string `json:"bindAddress"`
+}
+
+// Health configures the probe endpoints.
+type Health struct {
+	HealthProbeBindAddress string `json:"healthProbeBindAddress"`
+}
+
+// Default returns the configuration used for everything the file and flags leave out.
+func Default() *DaemonConfig {
+	return &DaemonConfig{
+		TypeMeta: metav1.TypeMeta{APIVersion: APIVersion, Kind: Kind},
+		IMDS: IMDS{
+			Endpoint:                  metadata.DefaultEndpoint,
+			Timeout:                   metav1.Duration{Duration: metadata.DefaultTimeout},
+			ScheduledEventsAPIVersion: metadata.DefaultScheduledEventsAPIVersion,
+		},
+		Interval: metav1.Duration{Duration: watcher.DefaultInterval},
+		Ack: Ack{
+			Policy:   strings.Split(ack.DefaultPolicy().String(), ","),
+			Deadline: metav1.Duration{Duration: ack.DefaultDeadline},
+		},
+		Conditions: Conditions{
+			Type:        watcher.DefaultConditionType,
+			Layout:      string(watcher.SingleCondition),
+			EventSource: node.DefaultEventSource,
+		},
+		InstanceFacts: InstanceFacts{
+			RefreshInterval: metav1.Duration{Duration: metadata.DefaultInstanceRefreshInterval},
+		},
+		Metrics: Metrics{BindAddress: "127.0.0.1:20261"},
+		Health:  Health{HealthProbeBindAddress: "127.0.0.1:20262"},
+	}
+}
+
+// AddFlags registers a flag for each setting on fs, defaulting to and setting the value in c.
+func (c *DaemonConfig) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.IMDS.Endpoint, "imds-endpoint", c.IMDS.Endpoint, "The base URL of the Azure Instance Metadata Service.")
+	fs.DurationVar(&c.IMDS.Timeout.Duration, "imds-timeout", c.IMDS.Timeout.Duration,
+		"The timeout for each request to the Azure Instance Metadata Service.")
+	fs.StringVar(&c.IMDS.ScheduledEventsAPIVersion, "imds-scheduled-events-api-version", c.IMDS.ScheduledEventsAPIVersion,
+		"The api-version of the scheduled events endpoint. Older versions are tried if the metadata service rejects it.")
+	fs.DurationVar(&c.Interval.Duration, "interval", c.Interval.Duration, "How often scheduled events are polled.")
+	fs.Var((*list)(&c.Ack.Policy), "ack-policy",
+		"Comma separated rules of the form EventType[/EventSource]=Immediate|Delay:<duration>|AfterDrain|Never. "+
+			"The first rule matching an event applies.")
+	fs.DurationVar(&c.Ack.Deadline.Duration, "ack-deadline", c.Ack.Deadline.Duration,
+		"How long before NotBefore an event is acknowledged even if its ack rule is not satisfied yet.")
+	fs.StringVar(&c.Conditions.Type, "condition-type", c.Conditions.Type,
+		"The node condition reporting the most urgent scheduled event. The conditions of each EventType replace its Maintenance "+
+			"prefix with the EventType, e.g. RebootScheduled for MaintenanceScheduled.")
+	fs.StringVar(&c.Conditions.Layout, "condition-layout", c.Conditions.Layout,
+		"The node conditions reporting scheduled events: single reports the most urgent event in -condition-type, "+
+			"per-event-type reports each EventType in its own condition, e.g. RebootScheduled, and both reports both.")
+	fs.StringVar(&c.Conditions.EventSource, "event-source", c.Conditions.EventSource,
+		"The component recorded as the source of Kubernetes events.")
+	fs.Var((*list)(&c.InstanceFacts.Allow), "instance-facts",
+		"Comma separated facts from the instance metadata to publish on the node, e.g. platform-update-domain,priority,tag:team. "+
+			"Labels and annotations are prefixed with "+facts.Prefix+", by default nothing is published.")
+	fs.DurationVar(&c.InstanceFacts.RefreshInterval.Duration, "instance-refresh-interval", c.InstanceFacts.RefreshInterval.Duration,
+		"How often the instance metadata is fetched to publish instance facts.")
+	fs.StringVar(&c.Metrics.BindAddress, "metrics-bind-address", c.Metrics.BindAddress, "The address the metric endpoint binds to.")
+	fs.StringVar(&c.Health.HealthProbeBindAddress, "health-probe-bind-address", c.Health.HealthProbeBindAddress,
+		"The address the probe endpoint binds to.")
+}
+
+// Override sets the values of the flags set on fs, which were registered with AddFlags, in c.
+func (c *DaemonConfig) Override(fs *flag.FlagSet) error {
+	overrides := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+	c.AddFlags(overrides)
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if err != nil || overrides.Lookup(f.Name) == nil {
+			return
+		}
+		if setErr := overrides.Set(f.Name, f.Value.String()); setErr != nil {
+			err = fmt.Errorf("invalid -%s: %w", f.Name, setErr)
+		}
+	})
+	return err
+}
+
+// Parse parses a configuration file, leaving the settings it does not mention at their defaults.
+func Parse(data []byte) (*DaemonConfig, error) {
+	c := Default()
+	c.TypeMeta = metav1.TypeMeta{}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return nil, fmt.Errorf("cannot parse config: %w", err)
+	}
+	return c, nil
+}
+
+// Load reads and parses the configuration file at path, applies the flags set on fs and
+// validates the result. An empty path loads the defaults.
+func Load(path string, fs *flag.FlagSet) (*DaemonConfig, error) {
+	if path == "" {
+		return build(Default(), fs)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := load(data, fs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+func load(data []byte, fs *flag.FlagSet) (*DaemonConfig, error) {
+	c, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	return build(c, fs)
+}
+
+// build applies the flags set on fs to c and validates the result.
+func build(c *DaemonConfig, fs *flag.FlagSet) (*DaemonConfig, error) {
+	if err := c.Override(fs); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate reports every invalid setting in c.
+func (c *DaemonConfig) Validate() error {
+	var errs field.ErrorList
+	if c.APIVersion != APIVersion {
+		errs = append(errs, field.NotSupported(field.NewPath("apiVersion"), c.APIVersion, []string{APIVersion}))
+	}
+	if c.Kind != Kind {
+		errs = append(errs, field.NotSupported(field.NewPath("kind"), c.Kind, []string{Kind}))
+	}
+	if u, err := url.Parse(c.IMDS.Endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+		errs = append(errs, field.Invalid(field.NewPath("imds", "endpoint"), c.IMDS.Endpoint, "must be an absolute URL"))
+	}
+	if c.IMDS.ScheduledEventsAPIVersion == "" {
+		errs = append(errs, field.Required(field.NewPath("imds", "scheduledEventsAPIVersion"), ""))
+	}
+	errs = append(errs, positive(field.NewPath("imds", "timeout"), c.IMDS.Timeout.Duration)...)
+	errs = append(errs, positive(field.NewPath("interval"), c.Interval.Duration)...)
+	if _, err := c.Policy(); err != nil {
+		errs = append(errs, field.Invalid(field.NewPath("ack", "policy"), c.Ack.Policy, err.Error()))
+	}
+	if c.Ack.Deadline.Duration < 0 {
+		errs = append(errs, field.Invalid(field.NewPath("ack", "deadline"), c.Ack.Deadline.Duration.String(), "must not be negative"))
+	}
+	if err := watcher.ValidateConditionType(c.Conditions.Type); err != nil {
+		errs = append(errs, field.Invalid(field.NewPath("conditions", "type"), c.Conditions.Type, err.Error()))
+	}
+	if _, err := watcher.ParseConditionLayout(c.Conditions.Layout); err != nil {
+		errs = append(errs, field.Invalid(field.NewPath("conditions", "layout"), c.Conditions.Layout, err.Error()))
+	}
+	if c.Conditions.EventSource == "" {
+		errs = append(errs, field.Required(field.NewPath("conditions", "eventSource"), ""))
+	}
+	if _, err := c.Allowlist(); err != nil {
+		errs = append(errs, field.Invalid(field.NewPath("instanceFacts", "allow"), c.InstanceFacts.Allow, err.Error()))
+	}
+	errs = append(errs, positive(field.NewPath("instanceFacts", "refreshInterval"), c.InstanceFacts.RefreshInterval.Duration)...)
+	return errs.ToAggregate()
+}
+
+func positive(path *field.Path, d time.Duration) field.ErrorList {
+	if d <= 0 {
+		return field.ErrorList{field.Invalid(path, d.String(), "must be positive")}
+	}
+	return nil
+}
+
+// Policy returns the ack.Policy configured by c.
+func (c *DaemonConfig) Policy() (ack.Policy, error) {
+	policy, err := ack.ParsePolicy(strings.Join(c.Ack.Policy, ","))
+	if err != nil {
+		return ack.Policy{}, err
+	}
+	policy.Deadline = c.Ack.Deadline.Duration
+	return policy, nil
+}
+
+// Layout returns the watcher.ConditionLayout configured by c.
+func (c *DaemonConfig) Layout() (watcher.ConditionLayout, error) {
+	return watcher.ParseConditionLayout(c.Conditions.Layout)
+}
+
+// Allowlist returns the facts.Allowlist configured by c.
+func (c *DaemonConfig) Allowlist() (facts.Allowlist, error) {
+	return facts.ParseAllowlist(strings.Join(c.InstanceFacts.Allow, ","))
+}
+
+// list is a flag.Value of comma separated strings.
+type list []string
+
+func (l *list) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *list) Set(value string) error {
+	*l = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package config
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"daemon/ack"
+)
+
+const file = `
+apiVersion: nodify.azure.microsoft.com/v1alpha1
+kind: DaemonConfig
+interval: 1m
+ack:
+  policy:
+  - Freeze=Immediate
+  - Reboot=Delay:5m
+imds:
+  endpoint: http://127.0.0.1:8169
+`
+
+func flags(t *testing.T, args ...string) *flag.FlagSet {
+	fs := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	Default().AddFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+func write(t *testing.T, dir, content string) string {
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := write(t, dir, file)
+
+	c, err := Load(path, flags(t, "-interval=2m", "-instance-facts=zone,tag:team"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Interval.Duration != 2*time.Minute {
+		t.Errorf("interval = %v, want the flag to override the file", c.Interval.Duration)
+	}
+	if c.IMDS.Endpoint != "http://127.0.0.1:8169" {
+		t.Errorf("imds.endpoint = %q, want the value in the file", c.IMDS.Endpoint)
+	}
+	if c.IMDS.Timeout != Default().IMDS.Timeout {
+		t.Errorf("imds.timeout = %v, want the default", c.IMDS.Timeout)
+	}
+	if want := []string{"zone", "tag:team"}; !reflect.DeepEqual(c.InstanceFacts.Allow, want) {
+		t.Errorf("instanceFacts.allow = %v, want %v", c.InstanceFacts.Allow, want)
+	}
+	policy, err := c.Policy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := policy.String(), "Freeze=Immediate,Reboot=Delay:5m0s"; got != want {
+		t.Errorf("policy = %s, want %s", got, want)
+	}
+	if policy.Deadline != ack.DefaultDeadline {
+		t.Errorf("deadline = %v, want %v", policy.Deadline, ack.DefaultDeadline)
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	c, err := Load("", flags(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c, Default()) {
+		t.Errorf("Load() = %+v, want the defaults", c)
+	}
+	policy, _ := c.Policy()
+	if got, want := policy.String(), ack.DefaultPolicy().String(); got != want {
+		t.Errorf("policy = %s, want %s", got, want)
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		args    []string
+		want    []string
+	}{
+		{"no version", "kind: DaemonConfig\n", nil, []string{"apiVersion: Unsupported value"}},
+		{"unknown field", file + "pollInterval: 1m\n", nil, []string{"unknown field"}},
+		{"bad duration", file + "ack:\n  deadline: soon\n", nil, []string{"cannot parse config"}},
+		{
+			"every invalid setting",
+			file + "conditions:\n  layout: sideways\n  eventSource: \"\"\n",
+			[]string{"-ack-policy=Reboot=Later", "-imds-timeout=0s", "-condition-type=Ready"},
+			[]string{"conditions.type", "conditions.layout", "conditions.eventSource", "ack.policy", "imds.timeout"},
+		},
+	}
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(write(t, dir, tt.content), flags(t, tt.args...))
+			if err == nil {
+				t.Fatal("Load() succeeded, want an error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Load() = %v, want it to mention %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := write(t, dir, file)
+	fs := flags(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := make(chan *DaemonConfig, 1)
+	go Watch(ctx, path, fs, time.Millisecond, func(c *DaemonConfig) {
+		select {
+		case changes <- c:
+		default:
+		}
+	})
+
+	// Watch may read the file after any of these writes, so every write differs.
+	write(t, dir, file+"conditions:\n  layout: sideways\n")
+	changed := strings.Replace(file, "Reboot=Delay:5m", "Reboot=Immediate", 1)
+	done := make(chan struct{})
+	defer func() {
+		cancel()
+		<-done
+	}()
+	go func() {
+		defer close(done)
+		for n := 0; ctx.Err() == nil; n++ {
+			_ = ioutil.WriteFile(path, []byte(fmt.Sprintf("%s# %d\n", changed, n)), 0600)
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	select {
+	case c := <-changes:
+		if got := c.Ack.Policy[1]; got != "Reboot=Immediate" {
+			t.Errorf("ack.policy[1] = %q, want the changed rule", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change observed")
+	}
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"io/ioutil"
+	"log"
+	"time"
+)
+
+// Watch calls onChange with the configuration every time the file at path changes, reading it
+// every interval until ctx is done. Kubernetes updates ConfigMap volumes by swapping a symlink,
+// so the content is compared rather than relying on file events. Changes that fail to load
+// are logged and otherwise ignored, the daemon keeps running with the last valid configuration.
+func Watch(ctx context.Context, path string, fs *flag.FlagSet, interval time.Duration, onChange func(*DaemonConfig)) {
+	last, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Printf("couldn't read config %s: %v\n", path, err)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Printf("couldn't read config %s: %v\n", path, err)
+			continue
+		}
+		if bytes.Equal(data, last) {
+			continue
+		}
+		last = data
+		c, err := load(data, fs)
+		if err != nil {
+			log.Printf("ignoring invalid config %s: %v\n", path, err)
+			continue
+		}
+		onChange(c)
+	}
+}
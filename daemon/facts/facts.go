@@ -0,0 +1,156 @@
+// Package facts publishes facts about the virtual machine from its instance metadata as labels
+// and annotations on the node, e.g. so maintenance can be coordinated by update domain.
+package facts
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"daemon/metadata"
+)
+
+const (
+	// Prefix qualifies every label and annotation published by a Syncer. Keys with this prefix are
+	// owned by the daemon, keys set by other agents are never modified.
+	Prefix = "instance.nodify.azure.microsoft.com/"
+
+	// tagPrefix selects an Azure tag of the virtual machine in an Allowlist, e.g. tag:team.
+	tagPrefix = "tag:"
+)
+
+// labels are the facts published as labels, keyed by their name in an Allowlist.
+var labels = map[string]func(*metadata.Compute) string{
+	"platform-fault-domain":  func(c *metadata.Compute) string { return c.PlatformFaultDomain },
+	"platform-update-domain": func(c *metadata.Compute) string { return c.PlatformUpdateDomain },
+	"priority":               func(c *metadata.Compute) string { return c.Priority },
+	"eviction-policy":        func(c *metadata.Compute) string { return c.EvictionPolicy },
+	"vm-size":                func(c *metadata.Compute) string { return c.VMSize },
+	"vm-scale-set-name":      func(c *metadata.Compute) string { return c.VMScaleSetName },
+	"zone":                   func(c *metadata.Compute) string { return c.Zone },
+}
+
+// Allowlist is the set of facts to publish. It contains names of labels, e.g.
+// platform-update-domain, and Azure tags of the virtual machine, e.g. tag:team, which are
+// published as annotations because tag values need not be valid label values.
+type Allowlist []string
+
+// ParseAllowlist parses a comma separated Allowlist. An empty value publishes nothing.
+func ParseAllowlist(value string) (Allowlist, error) {
+	var allow Allowlist
+	for _, fact := range strings.Split(value, ",") {
+		fact = strings.TrimSpace(fact)
+		switch {
+		case fact == "":
+			continue
+		case strings.HasPrefix(fact, tagPrefix):
+			if errs := validation.IsQualifiedName(Prefix + tagKey(strings.TrimPrefix(fact, tagPrefix))); len(errs) > 0 {
+				return nil, fmt.Errorf("invalid fact %q: %s", fact, strings.Join(errs, ", "))
+			}
+		case labels[fact] == nil:
+			return nil, fmt.Errorf("unknown fact %q, want one of %s or %s<name>", fact, strings.Join(known(), ", "), tagPrefix)
+		}
+		allow = append(allow, fact)
+	}
+	return allow, nil
+}
+
+func (a Allowlist) String() string {
+	return strings.Join(a, ",")
+}
+
+// Facts returns the labels and annotations allowed by a for instance. Facts the instance
+// metadata does not have, or whose value is not a valid label value, are omitted.
+func (a Allowlist) Facts(instance *metadata.Instance) (map[string]string, map[string]string) {
+	nodeLabels := map[string]string{}
+	annotations := map[string]string{}
+	tags := map[string]string{}
+	for _, tag := range instance.Compute.TagsList {
+		tags[tag.Name] = tag.Value
+	}
+	for _, fact := range a {
+		if strings.HasPrefix(fact, tagPrefix) {
+			name := strings.TrimPrefix(fact, tagPrefix)
+			if value, ok := tags[name]; ok {
+				annotations[Prefix+tagKey(name)] = value
+			}
+			continue
+		}
+		value := labels[fact](&instance.Compute)
+		if value == "" {
+			continue
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			log.Printf("not publishing fact %s=%q: %s\n", fact, value, strings.Join(errs, ", "))
+			continue
+		}
+		nodeLabels[Prefix+fact] = value
+	}
+	return nodeLabels, annotations
+}
+
+func tagKey(name string) string {
+	return "tag-" + name
+}
+
+func known() []string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Source provides the instance metadata of the virtual machine, e.g. a *metadata.Client.
+type Source interface {
+	Instance(ctx context.Context) (*metadata.Instance, error)
+}
+
+// Node is the Kubernetes node the facts are published on.
+type Node interface {
+	// SetInstanceFacts makes labels and annotations the only ones with Prefix on the node.
+	SetInstanceFacts(ctx context.Context, labels, annotations map[string]string) error
+}
+
+// Syncer periodically publishes the facts in an Allowlist on the node.
+type Syncer struct {
+	source   Source
+	node     Node
+	allow    Allowlist
+	interval time.Duration
+}
+
+// NewSyncer returns a Syncer publishing the facts in allow every interval.
+func NewSyncer(source Source, node Node, allow Allowlist, interval time.Duration) *Syncer {
+	return &Syncer{source: source, node: node, allow: allow, interval: interval}
+}
+
+// Run calls Sync immediately and then every interval until ctx is done.
+func (s *Syncer) Run(ctx context.Context) {
+	for {
+		if err := s.Sync(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("couldn't publish instance facts: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.interval):
+		}
+	}
+}
+
+// Sync publishes the facts once.
+func (s *Syncer) Sync(ctx context.Context) error {
+	instance, err := s.source.Instance(ctx)
+	if err != nil {
+		return err
+	}
+	nodeLabels, annotations := s.allow.Facts(instance)
+	return s.node.SetInstanceFacts(ctx, nodeLabels, annotations)
+}
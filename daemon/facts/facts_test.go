@@ -0,0 +1,49 @@
+package facts
+
+import (
+	"reflect"
+	"testing"
+
+	"daemon/metadata"
+)
+
+func TestParseAllowlist(t *testing.T) {
+	allow, err := ParseAllowlist("platform-update-domain, priority,tag:team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Allowlist{"platform-update-domain", "priority", "tag:team"}); !reflect.DeepEqual(allow, want) {
+		t.Errorf("ParseAllowlist() = %v, want %v", allow, want)
+	}
+	if allow, err := ParseAllowlist(""); err != nil || len(allow) != 0 {
+		t.Errorf("ParseAllowlist(\"\") = %v, %v, want nothing", allow, err)
+	}
+	for _, invalid := range []string{"update-domain", "tag:cost center"} {
+		if _, err := ParseAllowlist(invalid); err == nil {
+			t.Errorf("ParseAllowlist(%q) succeeded, want error", invalid)
+		}
+	}
+}
+
+func TestFacts(t *testing.T) {
+	allow, err := ParseAllowlist("platform-fault-domain,platform-update-domain,priority,eviction-policy,tag:team,tag:missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := &metadata.Instance{Compute: metadata.Compute{
+		PlatformFaultDomain:  "1",
+		PlatformUpdateDomain: "4",
+		TagsList:             []metadata.Tag{{Name: "team", Value: "infra & ops"}, {Name: "cost", Value: "42"}},
+	}}
+	labels, annotations := allow.Facts(instance)
+	wantLabels := map[string]string{
+		Prefix + "platform-fault-domain":  "1",
+		Prefix + "platform-update-domain": "4",
+	}
+	if !reflect.DeepEqual(labels, wantLabels) {
+		t.Errorf("labels = %v, want %v", labels, wantLabels)
+	}
+	if want := map[string]string{Prefix + "tag-team": "infra & ops"}; !reflect.DeepEqual(annotations, want) {
+		t.Errorf("annotations = %v, want %v", annotations, want)
+	}
+}
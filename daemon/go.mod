@@ -2,4 +2,10 @@ module daemon
 
 go 1.15
 
-require k8s.io/node-problem-detector v0.8.7
+require (
+	github.com/prometheus/client_golang v0.9.4
+	k8s.io/api v0.20.4
+	k8s.io/apimachinery v0.20.4
+	k8s.io/client-go v0.20.4
+	sigs.k8s.io/yaml v1.2.0
+)
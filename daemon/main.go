@@ -4,99 +4,107 @@ import (
 	"context"
 	"flag"
 	"log"
+	"net/http"
 	"os"
+	"reflect"
 	"time"
 
+	"daemon/config"
+	"daemon/facts"
 	"daemon/metadata"
-
-	"k8s.io/node-problem-detector/cmd/options"
-	"k8s.io/node-problem-detector/pkg/exporters/k8sexporter"
-	"k8s.io/node-problem-detector/pkg/types"
+	"daemon/metrics"
+	"daemon/node"
+	"daemon/watcher"
 )
 
 // +kubebuilder:rbac:groups="",resources=events;nodes,verbs=get;list;watch;create;update;delete;patch
 // +kubebuilder:rbac:groups="",resources=nodes/status,verbs=get;update;patch
 
-const interval = time.Second * 30
+const (
+	// shutdownTimeout bounds reporting that the daemon stopped. It must stay well within the
+	// DaemonSet's terminationGracePeriodSeconds of 10s.
+	shutdownTimeout = 5 * time.Second
+	// reloadInterval is how often the configuration file is checked for changes.
+	reloadInterval = 10 * time.Second
+)
 
 func main() {
+	var configFile string
+	var kubeconfig string
+	flag.StringVar(&configFile, "config", "",
+		"Path to a DaemonConfig file. Flags set on the command line override the values in the file.")
+	flag.StringVar(&kubeconfig, "kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
+	config.Default().AddFlags(flag.CommandLine)
 	flag.Parse()
-	npdo := options.NodeProblemDetectorOptions{
-		EnableK8sExporter:          true,
-		APIServerWaitTimeout:       time.Duration(5) * time.Minute, // nolint: gomnd
-		APIServerWaitInterval:      time.Duration(5) * time.Second, // nolint: gomnd
-		K8sExporterHeartbeatPeriod: time.Duration(5) * time.Minute, // nolint: gomnd
-		ServerAddress:              "127.0.0.1",
-		ServerPort:                 20260,
-		NodeName:                   os.Getenv("NODE_NAME"),
+	cfg, err := config.Load(configFile, flag.CommandLine)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v\n", err)
 	}
+	// Validated by Load.
+	policy, _ := cfg.Policy()
+	layout, _ := cfg.Layout()
+	allow, _ := cfg.Allowlist()
 
-	ctx := context.Background()
-	client := metadata.Client{}
+	ctx := signalContext()
+	client := metadata.NewClient(
+		metadata.WithEndpoint(cfg.IMDS.Endpoint),
+		metadata.WithTimeout(cfg.IMDS.Timeout.Duration),
+		metadata.WithScheduledEventsAPIVersion(cfg.IMDS.ScheduledEventsAPIVersion),
+		metadata.WithInstanceRefreshInterval(cfg.InstanceFacts.RefreshInterval.Duration),
+		metadata.WithTransportWrapper(metrics.InstrumentTransport),
+	)
+	nodes, err := node.NewClient(kubeconfig, os.Getenv("NODE_NAME"), node.WithEventSource(cfg.Conditions.EventSource))
+	if err != nil {
+		log.Fatalf("error creating kubernetes client: %v\n", err)
+	}
 
-	acknowledged := false
-	lastTransition := time.Now()
-	previousEvents := &metadata.ScheduledEvents{}
-	exporter := k8sexporter.NewExporterOrDie(&npdo)
-	for range time.Tick(interval) {
-		events, err := client.Scheduled(ctx)
-		if err != nil {
-			log.Fatalf("error getting scheduled events: %+v\n", err)
-		}
-		if events.DocumentIncarnation == previousEvents.DocumentIncarnation {
-			if !lastTransition.IsZero() && time.Since(lastTransition) >= time.Minute && !acknowledged {
-				log.Printf("AckAll: %+v", *events)
-				if err := client.AckAll(ctx, events); err != nil {
-					log.Printf("couldn't ack event: %v\n", err)
-				}
-				acknowledged = true
-			}
-			continue
-		}
-		log.Printf("events: %+v\npreviousEvents: %+v\n", events, previousEvents)
-		exporter.ExportProblems(convert(events))
-		acknowledged = false
-		previousEvents = events
-		lastTransition = time.Now()
+	w := watcher.New(client, nodes, nodes, policy,
+		watcher.WithInterval(cfg.Interval.Duration),
+		watcher.WithConditionLayout(layout),
+		watcher.WithConditionType(cfg.Conditions.Type),
+	)
+	if err := w.Restore(ctx); err != nil {
+		log.Printf("couldn't load daemon state, starting fresh: %v\n", err)
 	}
-}
 
-func convert(se *metadata.ScheduledEvents) *types.Status {
-	status := types.Status{Source: "nodify"}
-	for n := range se.Events {
-		event := types.Event{
-			Severity:  types.Warn,
-			Timestamp: time.Now(),
-			Reason:    se.Events[n].EventType,
-			Message:   se.Events[n].Description,
-		}
-		status.Events = append(status.Events, event)
-		condition := types.Condition{
-			Type:       "MaintenanceScheduled",
-			Status:     types.True,
-			Transition: time.Now(),
-			Reason:     se.Events[n].EventType,
-			Message:    se.Events[n].Description,
-		}
-		status.Conditions = append(status.Conditions, condition)
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("/metrics", metrics.Handler())
+	metricsServer := serve(cfg.Metrics.BindAddress, metricsMux)
+	probeMux := http.NewServeMux()
+	probeMux.Handle("/healthz", probe(w.Healthz))
+	probeMux.Handle("/readyz", probe(w.Readyz))
+	probeServer := serve(cfg.Health.HealthProbeBindAddress, probeMux)
+
+	if len(allow) > 0 {
+		go facts.NewSyncer(client, nodes, allow, cfg.InstanceFacts.RefreshInterval.Duration).Run(ctx)
+	}
+	if configFile != "" {
+		go config.Watch(ctx, configFile, flag.CommandLine, reloadInterval, func(changed *config.DaemonConfig) {
+			reload(w, cfg, changed)
+		})
 	}
-	if len(status.Conditions) == 0 {
-		return noMaintenance()
+	w.Run(ctx)
+
+	log.Printf("shutting down\n")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := w.Shutdown(shutdownCtx); err != nil {
+		log.Printf("couldn't report that the daemon stopped: %v\n", err)
 	}
-	return &status
+	_ = metricsServer.Close()
+	_ = probeServer.Close()
 }
 
-func noMaintenance() *types.Status {
-	return &types.Status{
-		Source: "nodify",
-		Conditions: []types.Condition{
-			{
-				Type:       "MaintenanceScheduled",
-				Status:     types.False,
-				Transition: time.Now(),
-				Reason:     "None",
-				Message:    "No maintenance scheduled.",
-			},
-		},
+// reload applies the settings of changed that can be changed while the daemon is running, which
+// is the ack policy, and logs that the daemon must be restarted to apply any other changes.
+func reload(w *watcher.Watcher, current, changed *config.DaemonConfig) {
+	if !reflect.DeepEqual(current.Ack, changed.Ack) {
+		// Validated by config.Watch.
+		policy, _ := changed.Policy()
+		w.SetPolicy(policy)
+		current.Ack = changed.Ack
+	}
+	if !reflect.DeepEqual(current, changed) {
+		log.Printf("configuration changed, restart the daemon to apply changes other than the ack policy\n")
 	}
 }
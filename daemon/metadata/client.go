@@ -0,0 +1,269 @@
+package metadata
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultEndpoint is the link-local address of the Azure Instance Metadata Service.
+	DefaultEndpoint = "http://169.254.169.254"
+	// DefaultTimeout bounds every request made by a Client created with NewClient.
+	DefaultTimeout = 10 * time.Second
+	// DefaultScheduledEventsAPIVersion is the newest api-version of the scheduled events endpoint
+	// the Client understands. It adds DurationInSeconds to every Event.
+	DefaultScheduledEventsAPIVersion = "2020-07-01"
+
+	defaultInstanceAPIVersion = "2020-09-01"
+
+	instancePath        = "/metadata/instance"
+	scheduledEventsPath = "/metadata/scheduledevents"
+)
+
+// scheduledEventsAPIVersions are the api-versions of the scheduled events endpoint the Client
+// understands, newest first.
+var scheduledEventsAPIVersions = []string{DefaultScheduledEventsAPIVersion, "2019-08-01", "2019-01-01", "2017-11-01"}
+
+// Client for fetching Virtual Machine metadata and events.
+type Client struct {
+	endpoint           string
+	instanceAPIVersion string
+	httpClient         *http.Client
+	instanceRefresh    time.Duration
+	// timeout and wrappers are applied to a copy of httpClient once every Option ran, so the
+	// order of the options doesn't matter and a client passed to WithHTTPClient is left alone.
+	timeout  *time.Duration
+	wrappers []func(http.RoundTripper) http.RoundTripper
+
+	mu sync.Mutex
+	// scheduledEventsAPIVersion is the configured api-version until the metadata service rejects it.
+	scheduledEventsAPIVersion string
+	instance                  *Instance
+	instanceFetched           time.Time
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithEndpoint overrides the base URL of the metadata service, e.g. to point at a local stub.
+func WithEndpoint(endpoint string) Option {
+	return func(c *Client) {
+		c.endpoint = endpoint
+	}
+}
+
+// WithInstanceAPIVersion overrides the api-version used for the instance endpoint.
+func WithInstanceAPIVersion(version string) Option {
+	return func(c *Client) {
+		c.instanceAPIVersion = version
+	}
+}
+
+// WithScheduledEventsAPIVersion overrides the api-version used for the scheduled events endpoint.
+// If the metadata service rejects it, the Client falls back to the older versions it understands.
+func WithScheduledEventsAPIVersion(version string) Option {
+	return func(c *Client) {
+		c.scheduledEventsAPIVersion = version
+	}
+}
+
+// WithHTTPClient replaces the http.Client used to talk to the metadata service. The Client uses
+// a copy of hc, so WithTimeout and WithTransportWrapper don't modify it. A nil hc is ignored.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		if hc != nil {
+			c.httpClient = hc
+		}
+	}
+}
+
+// WithTransportWrapper wraps the transport of the http.Client, e.g. to instrument requests.
+func WithTransportWrapper(wrap func(http.RoundTripper) http.RoundTripper) Option {
+	return func(c *Client) {
+		c.wrappers = append(c.wrappers, wrap)
+	}
+}
+
+// WithTimeout overrides the per request timeout of the http.Client.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = &timeout
+	}
+}
+
+// NewClient returns a Client for the Azure Instance Metadata Service. By default requests
+// go straight to DefaultEndpoint, bypassing any configured proxy, and time out after DefaultTimeout.
+func NewClient(opts ...Option) *Client {
+	c := &Client{
+		endpoint:                  DefaultEndpoint,
+		instanceAPIVersion:        defaultInstanceAPIVersion,
+		scheduledEventsAPIVersion: DefaultScheduledEventsAPIVersion,
+		httpClient:                newHTTPClient(),
+		instanceRefresh:           DefaultInstanceRefreshInterval,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	hc := *c.httpClient
+	if c.timeout != nil {
+		hc.Timeout = *c.timeout
+	}
+	for _, wrap := range c.wrappers {
+		if hc.Transport == nil {
+			hc.Transport = http.DefaultTransport
+		}
+		hc.Transport = wrap(hc.Transport)
+	}
+	c.httpClient = &hc
+	return c
+}
+
+func newHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// The metadata service is link-local and must never be reached through a proxy.
+	transport.Proxy = nil
+	return &http.Client{
+		Transport: transport,
+		Timeout:   DefaultTimeout,
+	}
+}
+
+// Scheduled returns ScheduledEvents containing a list of maintenance operations scheduled for the virtual machine.
+func (c *Client) Scheduled(ctx context.Context) (*ScheduledEvents, error) {
+	self, err := c.name(ctx)
+	if err != nil {
+		return nil, err
+	}
+	se, err := c.scheduledEvents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var filtered []Event
+	for n := range se.Events {
+		for _, resource := range se.Events[n].Resources {
+			if resource == self {
+				filtered = append(filtered, se.Events[n])
+				break
+			}
+		}
+	}
+	se.Events = filtered
+	return se, nil
+}
+
+// AckAll acknowledges every maintenance operation in scheduled with a single request.
+func (c *Client) AckAll(ctx context.Context, scheduled *ScheduledEvents) error {
+	ids := make([]string, 0, len(scheduled.Events))
+	for n := range scheduled.Events {
+		ids = append(ids, scheduled.Events[n].EventID)
+	}
+	return c.ack(ctx, ids...)
+}
+
+// Ack acknowledges a single maintenance operation so the platform may start it before NotBefore.
+func (c *Client) Ack(ctx context.Context, event *Event) error {
+	return c.ack(ctx, event.EventID)
+}
+
+func (c *Client) url(path, version string) string {
+	return fmt.Sprintf("%s%s?api-version=%s", c.endpoint, path, version)
+}
+
+// scheduledEvents gets the scheduled events document, falling back to older api-versions until
+// one is accepted by the metadata service. The accepted version is used from then on.
+func (c *Client) scheduledEvents(ctx context.Context) (*ScheduledEvents, error) {
+	for {
+		version := c.scheduledEventsVersion()
+		se, err := c.getScheduledEvents(ctx, version)
+		if err == nil || !versionRejected(err) {
+			return se, err
+		}
+		if !c.fallBack(version) {
+			return nil, err
+		}
+	}
+}
+
+func (c *Client) scheduledEventsVersion() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.scheduledEventsAPIVersion
+}
+
+// fallBack replaces the rejected version with the next older one the Client understands. It
+// reports false if there is none.
+func (c *Client) fallBack(rejected string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.scheduledEventsAPIVersion != rejected {
+		// Another request already fell back.
+		return true
+	}
+	for _, version := range scheduledEventsAPIVersions {
+		if version < rejected {
+			c.scheduledEventsAPIVersion = version
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) getScheduledEvents(ctx context.Context, version string) (*ScheduledEvents, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.url(scheduledEventsPath, version), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Metadata", "true")
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	events, err := ioutil.ReadAll(res.Body)
+	defer res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(res, events); err != nil {
+		return nil, err
+	}
+	se := ScheduledEvents{}
+	if err := json.Unmarshal(events, &se); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal json: %w\n%s", err, string(events))
+	}
+	return &se, nil
+}
+
+func (c *Client) ack(ctx context.Context, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	ack := scheduledEventsAck{StartRequests: make([]startRequest, 0, len(ids))}
+	for _, id := range ids {
+		ack.StartRequests = append(ack.StartRequests, startRequest{EventID: id})
+	}
+	body, err := json.Marshal(ack)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url(scheduledEventsPath, c.scheduledEventsVersion()), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Metadata", "true")
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	msg, err := ioutil.ReadAll(res.Body)
+	defer res.Body.Close()
+	if err != nil {
+		return err
+	}
+	return checkResponse(res, msg)
+}
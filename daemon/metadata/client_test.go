@@ -0,0 +1,175 @@
+package metadata_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"daemon/metadata"
+	"daemon/metadata/fake"
+)
+
+func TestScheduledFiltersOtherInstances(t *testing.T) {
+	srv := fake.NewServer("aks-nodepool1-21922338-vmss_39")
+	defer srv.Close()
+	srv.SetEvents(
+		metadata.Event{
+			EventID:   "FA298C74-AE95-4154-8EBF-303EED382DB6",
+			EventType: "Reboot",
+			NotBefore: metadata.TimeRFC1123{Time: time.Date(2021, 3, 30, 13, 39, 24, 0, time.UTC)},
+		},
+		metadata.Event{
+			EventID:   "D3D9DFEC-1DCA-4B49-97AA-780E02F45DFE",
+			EventType: "Freeze",
+			Resources: []string{"aks-nodepool1-21922338-vmss_47"},
+		},
+	)
+
+	se, err := srv.MetadataClient().Scheduled(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if se.DocumentIncarnation != 1 {
+		t.Errorf("DocumentIncarnation = %d, want 1", se.DocumentIncarnation)
+	}
+	if len(se.Events) != 1 || se.Events[0].EventType != "Reboot" {
+		t.Fatalf("Events = %+v, want only the Reboot event", se.Events)
+	}
+	if want := time.Date(2021, 3, 30, 13, 39, 24, 0, time.UTC); !se.Events[0].NotBefore.Equal(want) {
+		t.Errorf("NotBefore = %v, want %v", se.Events[0].NotBefore, want)
+	}
+}
+
+func TestScheduledIncarnation(t *testing.T) {
+	srv := fake.NewServer("vm")
+	defer srv.Close()
+	c := srv.MetadataClient()
+
+	srv.SetEvents(metadata.Event{EventID: "a", EventType: "Freeze"})
+	want := srv.SetEvents()
+	se, err := c.Scheduled(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if se.DocumentIncarnation != want || len(se.Events) != 0 {
+		t.Errorf("Scheduled() = %+v, want incarnation %d with no events", se, want)
+	}
+}
+
+func TestAckAll(t *testing.T) {
+	srv := fake.NewServer("vm")
+	defer srv.Close()
+
+	se := &metadata.ScheduledEvents{Events: []metadata.Event{{EventID: "a"}, {EventID: "b"}}}
+	if err := srv.MetadataClient().AckAll(context.Background(), se); err != nil {
+		t.Fatal(err)
+	}
+	if acks := srv.Acks(); len(acks) != 2 || acks[0] != "a" || acks[1] != "b" {
+		t.Errorf("Acks() = %v, want [a b]", acks)
+	}
+}
+
+func TestScheduledStatusError(t *testing.T) {
+	srv := fake.NewServer("vm")
+	defer srv.Close()
+	c := srv.MetadataClient()
+
+	srv.Fail(http.StatusTooManyRequests, 3*time.Second, 1)
+	_, err := c.Scheduled(context.Background())
+	var se *metadata.StatusError
+	if !errors.As(err, &se) {
+		t.Fatalf("Scheduled() error = %v, want *metadata.StatusError", err)
+	}
+	if se.StatusCode != http.StatusTooManyRequests || !se.Temporary() {
+		t.Errorf("StatusError = %+v, want temporary 429", se)
+	}
+	if got := metadata.RetryAfter(err); got != 3*time.Second {
+		t.Errorf("RetryAfter() = %v, want 3s", got)
+	}
+
+	srv.Fail(http.StatusNotFound, 0, 1)
+	if _, err := c.Scheduled(context.Background()); !errors.As(err, &se) || se.Temporary() {
+		t.Errorf("Scheduled() error = %v, want permanent *metadata.StatusError", err)
+	}
+
+	if _, err := c.Scheduled(context.Background()); err != nil {
+		t.Errorf("Scheduled() error = %v after faults were exhausted", err)
+	}
+}
+
+func TestScheduledAPIVersionFallback(t *testing.T) {
+	srv := fake.NewServer("vm")
+	defer srv.Close()
+	srv.SetEvents(metadata.Event{EventID: "a", EventType: "Freeze", DurationInSeconds: 5})
+	c := srv.MetadataClient()
+
+	se, err := c.Scheduled(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, ok := se.Events[0].Duration(); !ok || d != 5*time.Second {
+		t.Errorf("Duration() = %v, %t, want 5s with %s", d, ok, metadata.DefaultScheduledEventsAPIVersion)
+	}
+
+	srv.SetScheduledEventsAPIVersions("2019-01-01")
+	for n := 0; n < 2; n++ {
+		se, err = c.Scheduled(context.Background())
+		if err != nil {
+			t.Fatalf("Scheduled() error = %v, want a fall back to 2019-01-01", err)
+		}
+	}
+	if _, ok := se.Events[0].Duration(); ok {
+		t.Errorf("Duration() reported with 2019-01-01, want none")
+	}
+	if err := c.Ack(context.Background(), &se.Events[0]); err != nil {
+		t.Errorf("Ack() error = %v, want the negotiated api-version to be used", err)
+	}
+
+	srv.SetScheduledEventsAPIVersions("2016-01-01")
+	var statusErr *metadata.StatusError
+	if _, err := c.Scheduled(context.Background()); !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("Scheduled() error = %v, want 400 once no version is left", err)
+	}
+}
+
+type countingTransport struct {
+	next  http.RoundTripper
+	count int
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.count++
+	return t.next.RoundTrip(req)
+}
+
+func TestClientOptions(t *testing.T) {
+	srv := fake.NewServer("aks-nodepool1-21922338-vmss_39")
+	defer srv.Close()
+	shared := srv.Server.Client()
+	transport := shared.Transport
+	var counting *countingTransport
+	wrap := func(next http.RoundTripper) http.RoundTripper {
+		counting = &countingTransport{next: next}
+		return counting
+	}
+
+	client := metadata.NewClient(
+		metadata.WithEndpoint(srv.URL),
+		metadata.WithTimeout(time.Second),
+		metadata.WithTransportWrapper(wrap),
+		metadata.WithHTTPClient(shared),
+	)
+	if _, err := client.Scheduled(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if counting == nil || counting.count == 0 {
+		t.Error("the transport wrapper did not see any request, want it applied after WithHTTPClient")
+	}
+	if shared.Timeout != 0 || shared.Transport != transport {
+		t.Errorf("the client passed to WithHTTPClient was modified: %+v", shared)
+	}
+
+	metadata.NewClient(metadata.WithHTTPClient(nil), metadata.WithTimeout(time.Second), metadata.WithTransportWrapper(wrap))
+}
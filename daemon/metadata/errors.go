@@ -0,0 +1,75 @@
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StatusError is returned when the metadata service responds with a non-2xx status.
+type StatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	// RetryAfter is the delay requested by the Retry-After header, if any.
+	RetryAfter time.Duration
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s: %s", e.Method, e.URL, e.StatusCode, http.StatusText(e.StatusCode), e.Body)
+}
+
+// Temporary reports whether the request may succeed if retried, i.e. the metadata
+// service is throttling (429) or failing (5xx).
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
+// versionRejected reports whether err is the metadata service rejecting the api-version of a request.
+func versionRejected(err error) bool {
+	var se *StatusError
+	return errors.As(err, &se) && se.StatusCode == http.StatusBadRequest && strings.Contains(se.Body, "api-version")
+}
+
+// RetryAfter returns the delay the metadata service asked for before the next request,
+// or zero if err does not carry one.
+func RetryAfter(err error) time.Duration {
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.RetryAfter
+	}
+	return 0
+}
+
+func checkResponse(res *http.Response, body []byte) error {
+	if res.StatusCode >= http.StatusOK && res.StatusCode < http.StatusMultipleChoices {
+		return nil
+	}
+	return &StatusError{
+		Method:     res.Request.Method,
+		URL:        res.Request.URL.String(),
+		StatusCode: res.StatusCode,
+		RetryAfter: parseRetryAfter(res.Header.Get("Retry-After")),
+		Body:       strings.TrimSpace(string(body)),
+	}
+}
+
+// parseRetryAfter parses a Retry-After header given either in seconds or as an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
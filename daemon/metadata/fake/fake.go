@@ -0,0 +1,256 @@
+// Package fake provides an in-process emulator of the Azure Instance Metadata Service
+// for testing code that uses metadata.Client.
+package fake
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	"daemon/metadata"
+)
+
+const (
+	instancePath        = "/metadata/instance"
+	scheduledEventsPath = "/metadata/scheduledevents"
+
+	// durationAPIVersion is the first api-version reporting DurationInSeconds.
+	durationAPIVersion = "2020-07-01"
+)
+
+// IMDS is an http.Handler that serves the instance and scheduled events endpoints of the
+// Azure Instance Metadata Service from scripted documents.
+type IMDS struct {
+	mu               sync.Mutex
+	instance         metadata.Instance
+	instanceRequests int
+	events           metadata.ScheduledEvents
+	acks             []string
+	faults           []fault
+	versions         []string
+
+	// OnAck, if set, is called for every EventId acknowledged through a StartRequest.
+	OnAck func(eventID string)
+}
+
+// NewIMDS returns an IMDS for the virtual machine called name with no scheduled events.
+func NewIMDS(name string) *IMDS {
+	return &IMDS{instance: metadata.Instance{Compute: metadata.Compute{Name: name}}}
+}
+
+// Name returns the compute name reported by the instance endpoint.
+func (m *IMDS) Name() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.instance.Compute.Name
+}
+
+// SetInstance replaces the document served by the instance endpoint, including the compute name.
+func (m *IMDS) SetInstance(instance metadata.Instance) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.instance = instance
+}
+
+// InstanceRequests returns how many times the instance endpoint was requested.
+func (m *IMDS) InstanceRequests() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.instanceRequests
+}
+
+func (m *IMDS) getInstance() metadata.Instance {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.instanceRequests++
+	return m.instance
+}
+
+// SetEvents replaces the scheduled events document and increments its DocumentIncarnation,
+// which is returned. Events with no Resources are scheduled for this virtual machine.
+func (m *IMDS) SetEvents(events ...metadata.Event) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events.DocumentIncarnation++
+	m.events.Events = make([]metadata.Event, len(events))
+	for n := range events {
+		m.events.Events[n] = events[n]
+		if len(m.events.Events[n].Resources) == 0 {
+			m.events.Events[n].Resources = []string{m.instance.Compute.Name}
+		}
+	}
+	return m.events.DocumentIncarnation
+}
+
+// Events returns the current scheduled events document.
+func (m *IMDS) Events() metadata.ScheduledEvents {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	se := metadata.ScheduledEvents{
+		DocumentIncarnation: m.events.DocumentIncarnation,
+		Events:              append([]metadata.Event(nil), m.events.Events...),
+	}
+	return se
+}
+
+// SetScheduledEventsAPIVersions restricts the api-versions accepted by the scheduled events
+// endpoint, e.g. to emulate a metadata service that doesn't support the newest version yet.
+// By default every version is accepted.
+func (m *IMDS) SetScheduledEventsAPIVersions(versions ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.versions = versions
+}
+
+func (m *IMDS) acceptsVersion(version string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.versions) == 0 {
+		return true
+	}
+	for _, v := range m.versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// eventsFor returns the scheduled events document in the schema of version.
+func (m *IMDS) eventsFor(version string) metadata.ScheduledEvents {
+	se := m.Events()
+	if version < durationAPIVersion {
+		for n := range se.Events {
+			se.Events[n].DurationInSeconds = 0
+		}
+	}
+	return se
+}
+
+// Acks returns the EventIds acknowledged so far, in the order they were received.
+func (m *IMDS) Acks() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.acks...)
+}
+
+// Fail makes the next times requests fail with statusCode. A positive retryAfter is
+// sent as the Retry-After header, e.g. to emulate throttling with 429 Too Many Requests.
+func (m *IMDS) Fail(statusCode int, retryAfter time.Duration, times int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for n := 0; n < times; n++ {
+		m.faults = append(m.faults, fault{statusCode: statusCode, retryAfter: retryAfter})
+	}
+}
+
+func (m *IMDS) nextFault() (fault, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.faults) == 0 {
+		return fault{}, false
+	}
+	f := m.faults[0]
+	m.faults = m.faults[1:]
+	return f, true
+}
+
+// ServeHTTP implements http.Handler.
+func (m *IMDS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if f, ok := m.nextFault(); ok {
+		if f.retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(f.retryAfter/time.Second)))
+		}
+		http.Error(w, http.StatusText(f.statusCode), f.statusCode)
+		return
+	}
+	if r.Header.Get("Metadata") != "true" {
+		http.Error(w, "Required metadata header not specified", http.StatusBadRequest)
+		return
+	}
+	version := r.URL.Query().Get("api-version")
+	if version == "" {
+		http.Error(w, "Bad request. api-version was not specified in the request", http.StatusBadRequest)
+		return
+	}
+	if r.URL.Path == scheduledEventsPath && !m.acceptsVersion(version) {
+		http.Error(w, "Bad request. api-version is invalid or was not specified in the request", http.StatusBadRequest)
+		return
+	}
+	switch {
+	case r.URL.Path == instancePath && r.Method == http.MethodGet:
+		writeJSON(w, m.getInstance())
+	case r.URL.Path == scheduledEventsPath && r.Method == http.MethodGet:
+		writeJSON(w, m.eventsFor(version))
+	case r.URL.Path == scheduledEventsPath && r.Method == http.MethodPost:
+		m.ack(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (m *IMDS) ack(w http.ResponseWriter, r *http.Request) {
+	var ack scheduledEventsAck
+	if err := json.NewDecoder(r.Body).Decode(&ack); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m.mu.Lock()
+	for _, sr := range ack.StartRequests {
+		m.acks = append(m.acks, sr.EventID)
+	}
+	onAck := m.OnAck
+	m.mu.Unlock()
+	if onAck != nil {
+		for _, sr := range ack.StartRequests {
+			onAck(sr.EventID)
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Server is an IMDS listening on a local httptest.Server.
+type Server struct {
+	*IMDS
+	*httptest.Server
+}
+
+// NewServer starts a Server for the virtual machine called name. Callers should Close it when done.
+func NewServer(name string) *Server {
+	imds := NewIMDS(name)
+	return &Server{
+		IMDS:   imds,
+		Server: httptest.NewServer(imds),
+	}
+}
+
+// MetadataClient returns a metadata.Client that talks to s.
+func (s *Server) MetadataClient(opts ...metadata.Option) *metadata.Client {
+	opts = append([]metadata.Option{
+		metadata.WithEndpoint(s.URL),
+		metadata.WithHTTPClient(s.Server.Client()),
+	}, opts...)
+	return metadata.NewClient(opts...)
+}
+
+type fault struct {
+	statusCode int
+	retryAfter time.Duration
+}
+
+type scheduledEventsAck struct {
+	StartRequests []startRequest `json:"StartRequests"`
+}
+
+type startRequest struct {
+	EventID string `json:"EventId"`
+}
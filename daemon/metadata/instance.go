@@ -0,0 +1,121 @@
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// DefaultInstanceRefreshInterval is how long Instance reuses the instance metadata it last fetched.
+const DefaultInstanceRefreshInterval = 10 * time.Minute
+
+// Instance schema for the instance metadata of a Virtual Machine.
+type Instance struct {
+	Compute Compute `json:"compute"`
+}
+
+// Compute schema for the compute section of the instance metadata.
+type Compute struct {
+	Name                 string `json:"name,omitempty"`
+	VMID                 string `json:"vmId,omitempty"`
+	VMSize               string `json:"vmSize,omitempty"`
+	Location             string `json:"location,omitempty"`
+	Zone                 string `json:"zone,omitempty"`
+	PlatformFaultDomain  string `json:"platformFaultDomain,omitempty"`
+	PlatformUpdateDomain string `json:"platformUpdateDomain,omitempty"`
+	VMScaleSetName       string `json:"vmScaleSetName,omitempty"`
+	ResourceGroupName    string `json:"resourceGroupName,omitempty"`
+	SubscriptionID       string `json:"subscriptionId,omitempty"`
+	ResourceID           string `json:"resourceId,omitempty"`
+	OSType               string `json:"osType,omitempty"`
+	// Tags are the Azure tags of the Virtual Machine formatted as name:value pairs separated by
+	// semicolons. Prefer TagsList, which is unambiguous when names or values contain those characters.
+	Tags     string `json:"tags,omitempty"`
+	TagsList []Tag  `json:"tagsList,omitempty"`
+	// Priority is Spot for Spot Virtual Machines, and empty or Regular otherwise.
+	Priority string `json:"priority,omitempty"`
+	// EvictionPolicy is Deallocate or Delete for Spot Virtual Machines.
+	EvictionPolicy string `json:"evictionPolicy,omitempty"`
+}
+
+// Tag is an Azure tag of the Virtual Machine.
+type Tag struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Spot reports whether the Virtual Machine is a Spot Virtual Machine that may be evicted.
+func (c *Compute) Spot() bool {
+	return c.Priority == "Spot"
+}
+
+// WithInstanceRefreshInterval overrides DefaultInstanceRefreshInterval.
+func WithInstanceRefreshInterval(interval time.Duration) Option {
+	return func(c *Client) {
+		c.instanceRefresh = interval
+	}
+}
+
+// Instance returns the instance metadata of the Virtual Machine. It is fetched at most once
+// per refresh interval, the returned Instance is shared and must not be modified.
+func (c *Client) Instance(ctx context.Context) (*Instance, error) {
+	c.mu.Lock()
+	cached, fetched := c.instance, c.instanceFetched
+	c.mu.Unlock()
+	if cached != nil && time.Since(fetched) < c.instanceRefresh {
+		return cached, nil
+	}
+
+	instance, err := c.fetchInstance(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.instance, c.instanceFetched = instance, time.Now()
+	c.mu.Unlock()
+	return instance, nil
+}
+
+// name returns the compute name of the Virtual Machine, which never changes, so it is only
+// fetched if no instance metadata was fetched before.
+func (c *Client) name(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	cached := c.instance
+	c.mu.Unlock()
+	if cached != nil {
+		return cached.Compute.Name, nil
+	}
+	instance, err := c.Instance(ctx)
+	if err != nil {
+		return "", err
+	}
+	return instance.Compute.Name, nil
+}
+
+func (c *Client) fetchInstance(ctx context.Context) (*Instance, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.url(instancePath, c.instanceAPIVersion), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Metadata", "true")
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	defer res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(res, body); err != nil {
+		return nil, err
+	}
+	instance := &Instance{}
+	if err := json.Unmarshal(body, instance); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal json: %w\n%s", err, string(body))
+	}
+	return instance, nil
+}
@@ -0,0 +1,69 @@
+package metadata_test
+
+import (
+	"context"
+	"testing"
+
+	"daemon/metadata"
+	"daemon/metadata/fake"
+)
+
+func TestInstance(t *testing.T) {
+	srv := fake.NewServer("vm")
+	defer srv.Close()
+	srv.SetInstance(metadata.Instance{Compute: metadata.Compute{
+		Name:                 "aks-spot-21922338-vmss_3",
+		VMSize:               "Standard_D4s_v3",
+		Location:             "westus2",
+		Zone:                 "2",
+		PlatformFaultDomain:  "1",
+		PlatformUpdateDomain: "4",
+		VMScaleSetName:       "aks-spot-21922338-vmss",
+		TagsList:             []metadata.Tag{{Name: "team", Value: "infra"}},
+		Priority:             "Spot",
+		EvictionPolicy:       "Delete",
+	}})
+
+	instance, err := srv.MetadataClient().Instance(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := instance.Compute
+	if c.VMSize != "Standard_D4s_v3" || c.Zone != "2" || c.PlatformUpdateDomain != "4" || c.VMScaleSetName != "aks-spot-21922338-vmss" {
+		t.Errorf("Compute = %+v, want the document served by the instance endpoint", c)
+	}
+	if !c.Spot() || c.EvictionPolicy != "Delete" {
+		t.Errorf("Compute = %+v, want a Spot VM deleted on eviction", c)
+	}
+	if len(c.TagsList) != 1 || c.TagsList[0] != (metadata.Tag{Name: "team", Value: "infra"}) {
+		t.Errorf("TagsList = %+v, want [team:infra]", c.TagsList)
+	}
+}
+
+func TestInstanceCached(t *testing.T) {
+	srv := fake.NewServer("vm")
+	defer srv.Close()
+	c := srv.MetadataClient()
+
+	for n := 0; n < 3; n++ {
+		if _, err := c.Scheduled(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Instance(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := srv.InstanceRequests(); got != 1 {
+		t.Errorf("InstanceRequests() = %d, want the instance to be fetched once", got)
+	}
+
+	c = srv.MetadataClient(metadata.WithInstanceRefreshInterval(0))
+	for n := 0; n < 2; n++ {
+		if _, err := c.Instance(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := srv.InstanceRequests(); got != 3 {
+		t.Errorf("InstanceRequests() = %d, want every Instance() to refresh without a refresh interval", got)
+	}
+}
@@ -1,32 +1,20 @@
 package metadata
 
 import (
-	"bytes"
-	"context"
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
-	"net/http"
 	"strings"
 	"time"
 )
 
-const (
-	instanceURL        = "http://169.254.169.254/metadata/instance?api-version=2020-09-01"
-	scheduledEventsURL = "http://169.254.169.254/metadata/scheduledevents?api-version=2019-08-01"
-)
-
-// Client for fetching Virtual Machine metadata and events.
-type Client struct {
-	self string
-}
-
 // ScheduledEvents schema for Virtual Machine maintenance response.
 type ScheduledEvents struct {
 	DocumentIncarnation int     `json:"DocumentIncarnation,omitempty"`
 	Events              []Event `json:"Events,omitempty"`
 }
 
+// timeFormatGMT is the RFC1123 layout the metadata service uses, which always reports GMT.
+const timeFormatGMT = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// TimeRFC1123 is a time.Time that is encoded as an RFC1123 string, e.g. the NotBefore of an Event.
 type TimeRFC1123 struct{ time.Time }
 
 // Event schema for Virtual Machine maintenance events.
@@ -39,12 +27,33 @@ type Event struct {
 	NotBefore    TimeRFC1123 `json:"NotBefore,omitempty"`
 	Description  string      `json:"Description,omitempty"`
 	EventSource  string      `json:"EventSource,omitempty"`
+	// DurationInSeconds is the expected impact of the event, e.g. how long a Freeze pauses the
+	// virtual machine. It is zero with api-versions before 2020-07-01 and -1 if unknown.
+	DurationInSeconds int `json:"DurationInSeconds,omitempty"`
+}
+
+// Duration returns the expected impact of the event, or false if the metadata service didn't report it.
+func (e *Event) Duration() (time.Duration, bool) {
+	if e.DurationInSeconds <= 0 {
+		return 0, false
+	}
+	return time.Duration(e.DurationInSeconds) * time.Second, true
+}
+
+// MarshalJSON encodes t as an RFC1123 string, or an empty string if t is the zero time.
+func (t TimeRFC1123) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte(`""`), nil
+	}
+	return []byte(`"` + t.UTC().Format(timeFormatGMT) + `"`), nil
 }
 
+// UnmarshalJSON decodes an RFC1123 string. An empty string, e.g. the NotBefore of a Started
+// event, is decoded as the zero time.
 func (t *TimeRFC1123) UnmarshalJSON(data []byte) error {
 	dt := strings.Trim(string(data), "\"")
 	if dt == "" {
-		t.Time = time.Now()
+		t.Time = time.Time{}
 		return nil
 	}
 	result, err := time.Parse(time.RFC1123, dt)
@@ -55,14 +64,6 @@ func (t *TimeRFC1123) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
-type instanceMetadata struct {
-	Compute compute `json:"compute,omitempty"`
-}
-
-type compute struct {
-	Name string `json:"name,omitempty"`
-}
-
 type scheduledEventsAck struct {
 	StartRequests []startRequest `json:"StartRequests,omitempty"`
 }
@@ -70,105 +71,3 @@ type scheduledEventsAck struct {
 type startRequest struct {
 	EventID string `json:"EventId,omitempty"`
 }
-
-// Scheduled returns ScheduledEvents containing a list of maintenance operations scheduled for the virtual machine.
-func (c Client) Scheduled(ctx context.Context) (*ScheduledEvents, error) {
-	if c.self == "" {
-		im, err := c.instance(ctx)
-		if err != nil {
-			return nil, err
-		}
-		c.self = im.Compute.Name
-	}
-	se, err := scheduledEvents(ctx)
-	if err != nil {
-		return nil, err
-	}
-	var filtered []Event
-	for n := range se.Events {
-		for _, resource := range se.Events[n].Resources {
-			if resource == c.self {
-				filtered = append(filtered, se.Events[n])
-				break
-			}
-		}
-	}
-	se.Events = filtered
-	return se, nil
-}
-
-// AckAll acknowledges maintenance operations for execution.
-func (c Client) AckAll(ctx context.Context, scheduled *ScheduledEvents) error {
-	for n := range scheduled.Events {
-		if err := ackEvent(ctx, &scheduled.Events[n]); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func (c Client) instance(ctx context.Context) (instanceMetadata, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", instanceURL, nil)
-	if err != nil {
-		return instanceMetadata{}, err
-	}
-	req.Header.Add("Metadata", "true")
-	client := &http.Client{}
-	res, err := client.Do(req)
-	if err != nil {
-		return instanceMetadata{}, err
-	}
-	events, err := ioutil.ReadAll(res.Body)
-	defer res.Body.Close()
-	if err != nil {
-		return instanceMetadata{}, err
-	}
-	se := instanceMetadata{}
-	if err := json.Unmarshal(events, &se); err != nil {
-		return instanceMetadata{}, err
-	}
-	return se, nil
-}
-
-func scheduledEvents(ctx context.Context) (*ScheduledEvents, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", scheduledEventsURL, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Add("Metadata", "true")
-	client := &http.Client{}
-	res, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	events, err := ioutil.ReadAll(res.Body)
-	defer res.Body.Close()
-	if err != nil {
-		return nil, err
-	}
-	se := ScheduledEvents{}
-	if err := json.Unmarshal(events, &se); err != nil {
-		return nil, fmt.Errorf("cannot unmarshal json: %w\n%s", err, string(events))
-	}
-	return &se, nil
-}
-
-func ackEvent(ctx context.Context, event *Event) error {
-	body, err := json.Marshal(scheduledEventsAck{[]startRequest{{EventID: event.EventID}}})
-	if err != nil {
-		return err
-	}
-	req, err := http.NewRequestWithContext(ctx, "POST", scheduledEventsURL, bytes.NewReader(body))
-	if err != nil {
-		return err
-	}
-	req.Header.Add("Metadata", "true")
-	client := &http.Client{}
-	res, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	_, err = ioutil.ReadAll(res.Body)
-	defer res.Body.Close()
-	return err
-}
@@ -22,7 +22,10 @@ func TestEmptyNotBefore(t *testing.T) {
 `)
 	se := ScheduledEvents{}
 	if err := json.Unmarshal(scheduledEventsJSON, &se); err != nil {
-		t.Error(err)
+		t.Fatal(err)
+	}
+	if len(se.Events) != 1 || !se.Events[0].NotBefore.IsZero() {
+		t.Errorf("Events = %+v, want an empty NotBefore decoded as the zero time", se.Events)
 	}
 }
 
@@ -0,0 +1,142 @@
+// Package metrics exposes Prometheus metrics about the daemon's scheduled events handling.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"daemon/metadata"
+)
+
+const namespace = "nodify"
+
+// Registry holds every metric exposed by the daemon.
+var Registry = prometheus.NewRegistry()
+
+var (
+	imdsRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "imds",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of requests to the Azure Instance Metadata Service by endpoint, method and status.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12), // nolint: gomnd
+	}, []string{"endpoint", "method", "status"})
+
+	imdsRequestErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "imds",
+		Name:      "request_errors_total",
+		Help:      "Failed requests to the Azure Instance Metadata Service by endpoint, method and status.",
+	}, []string{"endpoint", "method", "status"})
+
+	documentIncarnation = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "scheduled_events",
+		Name:      "document_incarnation",
+		Help:      "DocumentIncarnation of the last scheduled events document.",
+	})
+
+	pendingEvents = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "scheduled_events",
+		Name:      "pending",
+		Help:      "Scheduled events for this virtual machine by EventType and EventStatus.",
+	}, []string{"event_type", "event_status"})
+
+	secondsUntilNotBefore = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "scheduled_events",
+		Name:      "seconds_until_not_before",
+		Help:      "Seconds until the NotBefore of each scheduled event, negative once it has passed.",
+	}, []string{"event_id", "event_type"})
+
+	acks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "scheduled_events",
+		Name:      "acks_total",
+		Help:      "Scheduled event acknowledgements by EventType and result.",
+	}, []string{"event_type", "result"})
+
+	ackLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "scheduled_events",
+		Name:      "detection_to_ack_seconds",
+		Help:      "Time from first seeing a scheduled event to acknowledging it by EventType.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 12), // nolint: gomnd
+	}, []string{"event_type"})
+)
+
+func init() { // nolint: gochecknoinits
+	Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		imdsRequestDuration,
+		imdsRequestErrors,
+		documentIncarnation,
+		pendingEvents,
+		secondsUntilNotBefore,
+		acks,
+		ackLatency,
+	)
+}
+
+// Handler serves the metrics in Registry.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// InstrumentTransport records the latency and errors of requests made through next. A nil
+// next uses http.DefaultTransport.
+func InstrumentTransport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		start := time.Now()
+		res, err := next.RoundTrip(req)
+		status := "error"
+		if err == nil {
+			status = strconv.Itoa(res.StatusCode)
+		}
+		labels := prometheus.Labels{"endpoint": req.URL.Path, "method": req.Method, "status": status}
+		imdsRequestDuration.With(labels).Observe(time.Since(start).Seconds())
+		if err != nil || res.StatusCode >= http.StatusBadRequest {
+			imdsRequestErrors.With(labels).Inc()
+		}
+		return res, err
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// ObserveScheduledEvents records the scheduled events document seen at now.
+func ObserveScheduledEvents(se *metadata.ScheduledEvents, now time.Time) {
+	documentIncarnation.Set(float64(se.DocumentIncarnation))
+	pendingEvents.Reset()
+	secondsUntilNotBefore.Reset()
+	for n := range se.Events {
+		event := &se.Events[n]
+		pendingEvents.WithLabelValues(event.EventType, event.EventStatus).Inc()
+		if !event.NotBefore.IsZero() {
+			secondsUntilNotBefore.WithLabelValues(event.EventID, event.EventType).Set(event.NotBefore.Sub(now).Seconds())
+		}
+	}
+}
+
+// ObserveAck records an acknowledgement of event first seen at firstSeen. err is the result of the ack.
+func ObserveAck(event *metadata.Event, firstSeen, now time.Time, err error) {
+	if err != nil {
+		acks.WithLabelValues(event.EventType, "failure").Inc()
+		return
+	}
+	acks.WithLabelValues(event.EventType, "success").Inc()
+	ackLatency.WithLabelValues(event.EventType).Observe(now.Sub(firstSeen).Seconds())
+}
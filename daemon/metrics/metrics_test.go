@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"daemon/metadata"
+)
+
+var start = time.Date(2021, 3, 30, 13, 0, 0, 0, time.UTC)
+
+func TestObserveScheduledEvents(t *testing.T) {
+	ObserveScheduledEvents(&metadata.ScheduledEvents{
+		DocumentIncarnation: 3,
+		Events: []metadata.Event{
+			{EventID: "a", EventType: "Reboot", EventStatus: "Scheduled", NotBefore: metadata.TimeRFC1123{Time: start.Add(10 * time.Minute)}},
+			{EventID: "b", EventType: "Reboot", EventStatus: "Scheduled", NotBefore: metadata.TimeRFC1123{Time: start.Add(5 * time.Minute)}},
+		},
+	}, start)
+	if got := testutil.ToFloat64(documentIncarnation); got != 3 {
+		t.Errorf("document_incarnation = %v, want 3", got)
+	}
+	if got := testutil.ToFloat64(pendingEvents.WithLabelValues("Reboot", "Scheduled")); got != 2 {
+		t.Errorf("pending{Reboot,Scheduled} = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(secondsUntilNotBefore.WithLabelValues("a", "Reboot")); got != 600 {
+		t.Errorf("seconds_until_not_before{a} = %v, want 600", got)
+	}
+
+	ObserveScheduledEvents(&metadata.ScheduledEvents{DocumentIncarnation: 4}, start)
+	if got := testutil.ToFloat64(pendingEvents.WithLabelValues("Reboot", "Scheduled")); got != 0 {
+		t.Errorf("pending{Reboot,Scheduled} = %v after the events cleared, want 0", got)
+	}
+}
+
+func TestObserveAck(t *testing.T) {
+	event := &metadata.Event{EventID: "a", EventType: "Freeze"}
+	ObserveAck(event, start, start.Add(time.Minute), nil)
+	ObserveAck(event, start, start.Add(time.Minute), errors.New("boom"))
+	if got := testutil.ToFloat64(acks.WithLabelValues("Freeze", "success")); got != 1 {
+		t.Errorf("acks_total{Freeze,success} = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(acks.WithLabelValues("Freeze", "failure")); got != 1 {
+		t.Errorf("acks_total{Freeze,failure} = %v, want 1", got)
+	}
+}
+
+func TestInstrumentTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: InstrumentTransport(nil)}
+	res, err := client.Get(srv.URL + "/metadata/scheduledevents")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	labels := []string{"/metadata/scheduledevents", http.MethodGet, "503"}
+	if got := testutil.ToFloat64(imdsRequestErrors.WithLabelValues(labels...)); got != 1 {
+		t.Errorf("request_errors_total%v = %v, want 1", labels, got)
+	}
+}
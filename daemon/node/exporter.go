@@ -0,0 +1,98 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+
+	"daemon/watcher"
+)
+
+// DefaultEventSource is the component recorded as the source of the Kubernetes events.
+const DefaultEventSource = "nodify"
+
+var _ watcher.Exporter = &Client{}
+
+// Export patches the conditions in status onto the node status, leaving conditions of other
+// types alone, and records the events in status. Failing to record an event is only logged, so
+// only failing to update the conditions is returned.
+func (c *Client) Export(ctx context.Context, status *watcher.Status) error {
+	if len(status.Conditions) > 0 {
+		if err := c.patchConditions(ctx, status.Conditions); err != nil {
+			return err
+		}
+	}
+	for n := range status.Events {
+		if err := c.recordEvent(ctx, &status.Events[n]); err != nil {
+			log.Printf("couldn't record event %s: %v\n", status.Events[n].Reason, err)
+		}
+	}
+	return nil
+}
+
+// patchConditions strategic merge patches conditions onto the node status. Node conditions are
+// merged by type, so the conditions of other agents are preserved.
+func (c *Client) patchConditions(ctx context.Context, conditions []watcher.Condition) error {
+	now := metav1.Now()
+	patched := make([]corev1.NodeCondition, 0, len(conditions))
+	for _, condition := range conditions {
+		patched = append(patched, corev1.NodeCondition{
+			Type:               corev1.NodeConditionType(condition.Type),
+			Status:             condition.Status,
+			LastHeartbeatTime:  now,
+			LastTransitionTime: metav1.NewTime(condition.Transition),
+			Reason:             condition.Reason,
+			Message:            condition.Message,
+		})
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": patched,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return retry.OnError(retry.DefaultBackoff, retriable, func() error {
+		_, err := c.clientset.CoreV1().Nodes().Patch(ctx, c.name, types.StrategicMergePatchType, patch,
+			metav1.PatchOptions{}, "status")
+		return err
+	})
+}
+
+// retriable reports whether a request to the API server that failed with err may succeed if retried.
+func retriable(err error) bool {
+	return apierrors.IsConflict(err) || apierrors.IsServerTimeout(err) || apierrors.IsTooManyRequests(err)
+}
+
+// recordEvent creates a Kubernetes event about the node.
+func (c *Client) recordEvent(ctx context.Context, event *watcher.Event) error {
+	timestamp := event.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	_, err := c.clientset.CoreV1().Events(metav1.NamespaceDefault).Create(ctx, &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%v.%x", c.name, time.Now().UnixNano()),
+			Namespace: metav1.NamespaceDefault,
+		},
+		// The kubelet uses the node name as the UID of events about the node.
+		InvolvedObject: corev1.ObjectReference{Kind: "Node", Name: c.name, UID: types.UID(c.name)},
+		Reason:         event.Reason,
+		Message:        event.Message,
+		Type:           event.Type,
+		Source:         corev1.EventSource{Component: c.eventSource, Host: c.name},
+		FirstTimestamp: metav1.NewTime(timestamp),
+		LastTimestamp:  metav1.NewTime(timestamp),
+		Count:          1,
+	}, metav1.CreateOptions{})
+	return err
+}
@@ -0,0 +1,70 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+
+	"daemon/watcher"
+)
+
+func TestExport(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node"},
+		Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{
+			{Type: corev1.NodeReady, Status: corev1.ConditionTrue, Reason: "KubeletReady"},
+			{Type: "MaintenanceScheduled", Status: corev1.ConditionFalse, Reason: "None"},
+		}},
+	})
+	conflicts := 1
+	clientset.PrependReactor("patch", "nodes", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if conflicts == 0 {
+			return false, nil, nil
+		}
+		conflicts--
+		return true, nil, apierrors.NewConflict(schema.GroupResource{Resource: "nodes"}, "node", nil)
+	})
+	c := NewForClientset(clientset, "node")
+
+	transition := time.Date(2021, 3, 30, 13, 0, 0, 0, time.UTC)
+	err := c.Export(context.Background(), &watcher.Status{
+		Events: []watcher.Event{{Type: corev1.EventTypeWarning, Reason: "Reboot", Message: "Reboot scheduled."}},
+		Conditions: []watcher.Condition{
+			{Type: "MaintenanceScheduled", Status: corev1.ConditionTrue, Transition: transition, Reason: "Reboot"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node, err := clientset.CoreV1().Nodes().Get(context.Background(), "node", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conditions := map[corev1.NodeConditionType]corev1.NodeCondition{}
+	for _, condition := range node.Status.Conditions {
+		conditions[condition.Type] = condition
+	}
+	if len(conditions) != 2 || conditions[corev1.NodeReady].Reason != "KubeletReady" {
+		t.Errorf("conditions = %+v, want the Ready condition preserved", node.Status.Conditions)
+	}
+	if c := conditions["MaintenanceScheduled"]; c.Reason != "Reboot" || !c.LastTransitionTime.Equal(&metav1.Time{Time: transition}) {
+		t.Errorf("MaintenanceScheduled = %+v, want Reboot since %v", c, transition)
+	}
+
+	events, err := clientset.CoreV1().Events(metav1.NamespaceDefault).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events.Items) != 1 || events.Items[0].Reason != "Reboot" || events.Items[0].InvolvedObject.Name != "node" {
+		t.Errorf("events = %+v, want a Reboot event about the node", events.Items)
+	}
+}
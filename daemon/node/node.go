@@ -0,0 +1,249 @@
+// Package node reads and writes the annotations the daemon uses to coordinate with the controller.
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"daemon/facts"
+	"daemon/metadata"
+	"daemon/watcher"
+)
+
+const (
+	// ScheduledEventsAnnotation lists the EventIds the daemon is waiting on the controller to drain for.
+	ScheduledEventsAnnotation = "nodify.azure.microsoft.com/scheduled-events"
+	// DrainedEventsAnnotation lists the EventIds for which the controller finished draining the node.
+	DrainedEventsAnnotation = "nodify.azure.microsoft.com/drained-events"
+	// EventAnnotationPrefix is followed by an EventId in the key of the annotation holding the
+	// ScheduledEvent for that event.
+	EventAnnotationPrefix = "events.nodify.azure.microsoft.com/"
+	// StateAnnotation holds the watcher.State of the node so a restarted daemon picks up where it left off.
+	StateAnnotation = "nodify.azure.microsoft.com/daemon-state"
+)
+
+// ScheduledEvent is the machine readable form of a scheduled event published on the node.
+type ScheduledEvent struct {
+	EventID           string     `json:"eventId"`
+	EventType         string     `json:"eventType"`
+	EventStatus       string     `json:"eventStatus,omitempty"`
+	EventSource       string     `json:"eventSource,omitempty"`
+	ResourceType      string     `json:"resourceType,omitempty"`
+	Resources         []string   `json:"resources,omitempty"`
+	NotBefore         *time.Time `json:"notBefore,omitempty"`
+	DurationInSeconds int        `json:"durationInSeconds,omitempty"`
+	Description       string     `json:"description,omitempty"`
+}
+
+func newScheduledEvent(event *metadata.Event) ScheduledEvent {
+	se := ScheduledEvent{
+		EventID:           event.EventID,
+		EventType:         event.EventType,
+		EventStatus:       event.EventStatus,
+		EventSource:       event.EventSource,
+		ResourceType:      event.ResourceType,
+		Resources:         event.Resources,
+		DurationInSeconds: event.DurationInSeconds,
+		Description:       event.Description,
+	}
+	if !event.NotBefore.IsZero() {
+		notBefore := event.NotBefore.UTC()
+		se.NotBefore = &notBefore
+	}
+	return se
+}
+
+// Client implements watcher.Node for the Kubernetes node called name.
+type Client struct {
+	clientset   kubernetes.Interface
+	name        string
+	eventSource string
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithEventSource overrides DefaultEventSource.
+func WithEventSource(component string) Option {
+	return func(c *Client) {
+		c.eventSource = component
+	}
+}
+
+var (
+	_ watcher.Node = &Client{}
+	_ facts.Node   = &Client{}
+)
+
+// NewClient returns a Client using kubeconfig, or the in-cluster config if kubeconfig is empty.
+func NewClient(kubeconfig, name string, opts ...Option) (*Client, error) {
+	var cfg *rest.Config
+	var err error
+	if kubeconfig != "" {
+		cfg, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	} else {
+		cfg, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewForClientset(clientset, name, opts...), nil
+}
+
+// NewForClientset returns a Client using clientset.
+func NewForClientset(clientset kubernetes.Interface, name string, opts ...Option) *Client {
+	c := &Client{clientset: clientset, name: name, eventSource: DefaultEventSource}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// SetScheduledEvents publishes the EventIds of events for the controller to drain for, and a
+// ScheduledEvent annotation for each of them. Annotations of events that are gone are removed.
+func (c *Client) SetScheduledEvents(ctx context.Context, events []metadata.Event) error {
+	node, err := c.clientset.CoreV1().Nodes().Get(ctx, c.name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	ids := make([]string, 0, len(events))
+	details := make(map[string]string, len(events))
+	for n := range events {
+		ids = append(ids, events[n].EventID)
+		value, err := json.Marshal(newScheduledEvent(&events[n]))
+		if err != nil {
+			return err
+		}
+		details[EventAnnotationPrefix+events[n].EventID] = string(value)
+	}
+	sort.Strings(ids)
+	annotations := prefixedDiff(node.Annotations, details, EventAnnotationPrefix)
+	current, ok := node.Annotations[ScheduledEventsAnnotation]
+	if joined := strings.Join(ids, ","); len(ids) > 0 && joined != current {
+		annotations[ScheduledEventsAnnotation] = &joined
+	} else if len(ids) == 0 && ok {
+		// Only remove the annotation if it exists, a null in a patch creates missing annotations.
+		annotations[ScheduledEventsAnnotation] = nil
+	}
+	if len(annotations) == 0 {
+		return nil
+	}
+	return c.annotate(ctx, annotations)
+}
+
+// DrainedEvents returns the EventIds the controller has finished draining the node for.
+func (c *Client) DrainedEvents(ctx context.Context) (map[string]bool, error) {
+	node, err := c.clientset.CoreV1().Nodes().Get(ctx, c.name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return splitEventIDs(node.Annotations[DrainedEventsAnnotation]), nil
+}
+
+// LoadState returns the watcher.State persisted on the node, or the zero watcher.State if there is none.
+func (c *Client) LoadState(ctx context.Context) (watcher.State, error) {
+	node, err := c.clientset.CoreV1().Nodes().Get(ctx, c.name, metav1.GetOptions{})
+	if err != nil {
+		return watcher.State{}, err
+	}
+	state := watcher.State{}
+	value, ok := node.Annotations[StateAnnotation]
+	if !ok {
+		return state, nil
+	}
+	if err := json.Unmarshal([]byte(value), &state); err != nil {
+		return watcher.State{}, fmt.Errorf("cannot unmarshal %s annotation: %w", StateAnnotation, err)
+	}
+	return state, nil
+}
+
+// SaveState persists state on the node.
+func (c *Client) SaveState(ctx context.Context, state watcher.State) error {
+	value, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	s := string(value)
+	return c.annotate(ctx, map[string]*string{StateAnnotation: &s})
+}
+
+// SetInstanceFacts makes labels and annotations the only ones with facts.Prefix on the node.
+// Labels and annotations without facts.Prefix belong to other agents and are left alone.
+func (c *Client) SetInstanceFacts(ctx context.Context, labels, annotations map[string]string) error {
+	node, err := c.clientset.CoreV1().Nodes().Get(ctx, c.name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	labelsPatch := prefixedDiff(node.Labels, labels, facts.Prefix)
+	annotationsPatch := prefixedDiff(node.Annotations, annotations, facts.Prefix)
+	if len(labelsPatch) == 0 && len(annotationsPatch) == 0 {
+		return nil
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels":      labelsPatch,
+			"annotations": annotationsPatch,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.clientset.CoreV1().Nodes().Patch(ctx, c.name, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// prefixedDiff returns the changes that make want the only keys with prefix in current. A nil
+// value removes the key.
+func prefixedDiff(current, want map[string]string, prefix string) map[string]*string {
+	diff := map[string]*string{}
+	for key := range current {
+		if _, ok := want[key]; !ok && strings.HasPrefix(key, prefix) {
+			diff[key] = nil
+		}
+	}
+	for key, value := range want {
+		if old, ok := current[key]; !ok || old != value {
+			value := value
+			diff[key] = &value
+		}
+	}
+	return diff
+}
+
+// annotate sets, or removes if nil, the given annotations on the node.
+func (c *Client) annotate(ctx context.Context, annotations map[string]*string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.clientset.CoreV1().Nodes().Patch(ctx, c.name, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+func splitEventIDs(value string) map[string]bool {
+	ids := map[string]bool{}
+	for _, id := range strings.Split(value, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids[id] = true
+		}
+	}
+	return ids
+}
@@ -0,0 +1,139 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+
+	"daemon/ack"
+	"daemon/facts"
+	"daemon/metadata"
+	"daemon/watcher"
+)
+
+func newTestClient() *Client {
+	return NewForClientset(fake.NewSimpleClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node"}}), "node")
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	c := newTestClient()
+	state, err := c.LoadState(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.DocumentIncarnation != 0 || len(state.Events) != 0 {
+		t.Errorf("LoadState() = %+v, want the zero state", state)
+	}
+
+	seen := time.Date(2021, 3, 30, 13, 0, 0, 0, time.UTC)
+	want := watcher.State{
+		DocumentIncarnation: 213,
+		Events: map[string]ack.EventState{
+			"a": {FirstSeen: seen, Acked: true},
+			"b": {FirstSeen: seen.Add(time.Minute)},
+		},
+	}
+	if err := c.SaveState(context.Background(), want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.LoadState(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.DocumentIncarnation != want.DocumentIncarnation || len(got.Events) != len(want.Events) {
+		t.Fatalf("LoadState() = %+v, want %+v", got, want)
+	}
+	for id, es := range want.Events {
+		if !got.Events[id].FirstSeen.Equal(es.FirstSeen) || got.Events[id].Acked != es.Acked {
+			t.Errorf("Events[%s] = %+v, want %+v", id, got.Events[id], es)
+		}
+	}
+}
+
+func TestScheduledEvents(t *testing.T) {
+	c := newTestClient()
+	notBefore := time.Date(2021, 3, 30, 13, 39, 24, 0, time.UTC)
+	events := []metadata.Event{
+		{EventID: "b", EventType: "Reboot", EventStatus: "Scheduled", NotBefore: metadata.TimeRFC1123{Time: notBefore}},
+		{EventID: "a", EventType: "Freeze", EventStatus: "Started", DurationInSeconds: 5},
+	}
+	if err := c.SetScheduledEvents(context.Background(), events); err != nil {
+		t.Fatal(err)
+	}
+	node, err := c.clientset.CoreV1().Nodes().Get(context.Background(), "node", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := node.Annotations[ScheduledEventsAnnotation]; got != "a,b" {
+		t.Errorf("%s = %q, want %q", ScheduledEventsAnnotation, got, "a,b")
+	}
+	var reboot ScheduledEvent
+	if err := json.Unmarshal([]byte(node.Annotations[EventAnnotationPrefix+"b"]), &reboot); err != nil {
+		t.Fatal(err)
+	}
+	if reboot.EventType != "Reboot" || reboot.EventStatus != "Scheduled" || reboot.NotBefore == nil || !reboot.NotBefore.Equal(notBefore) {
+		t.Errorf("%sb = %+v, want the Reboot scheduled NotBefore %v", EventAnnotationPrefix, reboot, notBefore)
+	}
+	if freeze := node.Annotations[EventAnnotationPrefix+"a"]; strings.Contains(freeze, "notBefore") {
+		t.Errorf("%sa = %s, want the Started event without notBefore", EventAnnotationPrefix, freeze)
+	}
+
+	if err := c.SetScheduledEvents(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	node, err = c.clientset.CoreV1().Nodes().Get(context.Background(), "node", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(node.Annotations) != 0 {
+		t.Errorf("annotations = %v, want every scheduled event removed", node.Annotations)
+	}
+}
+
+func TestSetInstanceFacts(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name: "node",
+		Labels: map[string]string{
+			"kubernetes.azure.com/agentpool":        "spot",
+			facts.Prefix + "platform-update-domain": "3",
+			facts.Prefix + "priority":               "Spot",
+		},
+	}})
+	c := NewForClientset(clientset, "node")
+
+	labels := map[string]string{facts.Prefix + "platform-update-domain": "4"}
+	if err := c.SetInstanceFacts(context.Background(), labels, nil); err != nil {
+		t.Fatal(err)
+	}
+	patch := string(clientset.Actions()[len(clientset.Actions())-1].(clienttesting.PatchAction).GetPatch())
+	for _, want := range []string{`"` + facts.Prefix + `platform-update-domain":"4"`, `"` + facts.Prefix + `priority":null`} {
+		if !strings.Contains(patch, want) {
+			t.Errorf("patch = %s, want it to contain %s", patch, want)
+		}
+	}
+	if strings.Contains(patch, "agentpool") {
+		t.Errorf("patch = %s, want labels set by other agents left alone", patch)
+	}
+
+	node, _ := clientset.CoreV1().Nodes().Get(context.Background(), "node", metav1.GetOptions{})
+	node.Labels = map[string]string{facts.Prefix + "platform-update-domain": "4"}
+	if _, err := clientset.CoreV1().Nodes().Update(context.Background(), node, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	clientset.ClearActions()
+	if err := c.SetInstanceFacts(context.Background(), labels, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == "patch" {
+			t.Errorf("SetInstanceFacts() patched the node although the facts did not change")
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package watcher
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// staleIntervals is the number of intervals without a successful poll after which the
+// Watcher is no longer healthy.
+const staleIntervals = 4
+
+// health is what the probes know about the polling loop. Step updates it while the probes
+// read it from the HTTP server's goroutines.
+type health struct {
+	mu sync.Mutex
+	// started is when Run started polling.
+	started time.Time
+	// lastPoll is when the metadata service last returned the scheduled events.
+	lastPoll time.Time
+	// nodeSynced is set once the node has been read or written through the API server.
+	nodeSynced bool
+}
+
+func (h *health) start(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.started = now
+}
+
+func (h *health) polled(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastPoll = now
+}
+
+func (h *health) synced() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nodeSynced = true
+}
+
+// Healthz reports an error unless the metadata service was polled successfully within the
+// last few intervals, so a wedged daemon is restarted instead of leaving stale conditions.
+func (w *Watcher) Healthz(_ *http.Request) error {
+	w.health.mu.Lock()
+	defer w.health.mu.Unlock()
+	if w.health.started.IsZero() {
+		// Still starting up, Readyz reports that.
+		return nil
+	}
+	last := w.health.lastPoll
+	if last.IsZero() {
+		last = w.health.started
+	}
+	if stale := w.clock.Since(last); stale > staleIntervals*w.interval {
+		return fmt.Errorf("no successful poll of scheduled events in %v", stale.Round(time.Second))
+	}
+	return nil
+}
+
+// Readyz reports an error until the instance has been looked up, scheduled events have been
+// polled and the node has been reached through the API server.
+func (w *Watcher) Readyz(_ *http.Request) error {
+	w.health.mu.Lock()
+	defer w.health.mu.Unlock()
+	if w.health.lastPoll.IsZero() {
+		return errors.New("scheduled events have not been polled yet")
+	}
+	if !w.health.nodeSynced {
+		return errors.New("node has not been reached through the API server yet")
+	}
+	return nil
+}
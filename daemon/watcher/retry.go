@@ -0,0 +1,59 @@
+package watcher
+
+import (
+	"math"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"daemon/metadata"
+)
+
+const (
+	retryInitialDelay = time.Second
+	retryFactor       = 2.0
+	retryJitter       = 0.2
+	// maxConsecutiveFailures is the number of failed polls before the outage is reported on the node.
+	maxConsecutiveFailures = 5
+)
+
+// retrier computes exponential backoff with jitter between failed requests to the metadata service.
+type retrier struct {
+	interval time.Duration
+	backoff  wait.Backoff
+	failures int
+}
+
+func newRetrier(interval time.Duration) *retrier {
+	r := &retrier{interval: interval}
+	r.success()
+	return r
+}
+
+// failure records a failed request and returns how long to wait before the next one.
+// The delay never exceeds the polling interval unless the metadata service asks for more with Retry-After.
+func (r *retrier) failure(err error) time.Duration {
+	r.failures++
+	delay := r.backoff.Step()
+	if retryAfter := metadata.RetryAfter(err); retryAfter > delay {
+		delay = retryAfter
+	}
+	return delay
+}
+
+// success resets the backoff after a successful request.
+func (r *retrier) success() {
+	r.failures = 0
+	r.backoff = wait.Backoff{
+		Duration: retryInitialDelay,
+		Factor:   retryFactor,
+		Jitter:   retryJitter,
+		Steps:    math.MaxInt32,
+		Cap:      r.interval,
+	}
+}
+
+// unavailable reports whether enough consecutive requests failed to consider the metadata service down.
+func (r *retrier) unavailable() bool {
+	return r.failures >= maxConsecutiveFailures
+}
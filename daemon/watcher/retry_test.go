@@ -0,0 +1,46 @@
+package watcher
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"daemon/metadata"
+)
+
+const interval = 30 * time.Second
+
+func TestRetrier(t *testing.T) {
+	r := newRetrier(interval)
+	err := errors.New("connection refused")
+	var previous time.Duration
+	for n := 1; n <= 10; n++ {
+		delay := r.failure(err)
+		if delay > time.Duration(float64(interval)*(1+retryJitter)) {
+			t.Errorf("failure %d: delay %v exceeds interval %v", n, delay, interval)
+		}
+		if n < 4 && delay < previous {
+			t.Errorf("failure %d: delay %v shorter than previous %v", n, delay, previous)
+		}
+		previous = delay
+		if got, want := r.unavailable(), n >= maxConsecutiveFailures; got != want {
+			t.Errorf("failure %d: unavailable() = %v, want %v", n, got, want)
+		}
+	}
+
+	r.success()
+	if r.unavailable() || r.failures != 0 {
+		t.Errorf("success() did not reset failures: %d", r.failures)
+	}
+	if delay := r.failure(err); delay > time.Duration(float64(retryInitialDelay)*(1+retryJitter)) {
+		t.Errorf("delay after success = %v, want about %v", delay, retryInitialDelay)
+	}
+}
+
+func TestRetrierRetryAfter(t *testing.T) {
+	r := newRetrier(interval)
+	err := &metadata.StatusError{StatusCode: 429, RetryAfter: 2 * time.Minute}
+	if delay := r.failure(err); delay != 2*time.Minute {
+		t.Errorf("failure() = %v, want Retry-After of 2m", delay)
+	}
+}
@@ -0,0 +1,281 @@
+package watcher
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"daemon/metadata"
+)
+
+// Status is what the Watcher reports on the node through an Exporter.
+type Status struct {
+	// Events are recorded as Kubernetes events about the node.
+	Events []Event
+	// Conditions replace the node conditions of the same Type.
+	Conditions []Condition
+}
+
+// Event is a Kubernetes event about the node.
+type Event struct {
+	// Type is corev1.EventTypeNormal or corev1.EventTypeWarning.
+	Type      string
+	Timestamp time.Time
+	Reason    string
+	Message   string
+}
+
+// Condition is a node condition owned by the daemon.
+type Condition struct {
+	Type       string
+	Status     corev1.ConditionStatus
+	Transition time.Time
+	Reason     string
+	Message    string
+}
+
+// DefaultConditionType is the condition reporting the most urgent scheduled event.
+const DefaultConditionType = "MaintenanceScheduled"
+
+// reservedConditionTypes are owned by the kubelet or reported by the daemon otherwise.
+var reservedConditionTypes = []string{
+	"Ready", "MemoryPressure", "DiskPressure", "PIDPressure", "NetworkUnavailable", "ScheduledEventsUnavailable",
+}
+
+// ValidateConditionType reports whether conditionType can name the condition reporting the most
+// urgent scheduled event. It must be alphanumeric, since the conditions of each EventType are
+// named after it, see EventTypeCondition.
+func ValidateConditionType(conditionType string) error {
+	if conditionType == "" {
+		return fmt.Errorf("the condition type must not be empty")
+	}
+	for n, r := range conditionType {
+		if !(r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || n > 0 && r >= '0' && r <= '9') {
+			return fmt.Errorf("condition type %q must start with a letter and be alphanumeric", conditionType)
+		}
+	}
+	for _, reserved := range reservedConditionTypes {
+		if conditionType == reserved {
+			return fmt.Errorf("condition type %q is reserved", conditionType)
+		}
+	}
+	return nil
+}
+
+// EventTypeCondition returns the condition reporting the events of eventType with the
+// PerEventTypeCondition layout: conditionType with its Maintenance prefix replaced by the
+// EventType, e.g. RebootScheduled for MaintenanceScheduled, or prefixed by the EventType if it
+// has no such prefix.
+func EventTypeCondition(conditionType, eventType string) string {
+	return eventType + strings.TrimPrefix(conditionType, "Maintenance")
+}
+
+// ConditionLayout selects the node conditions reporting scheduled events.
+type ConditionLayout string
+
+const (
+	// SingleCondition reports the most urgent event in a single condition, MaintenanceScheduled
+	// by default.
+	SingleCondition ConditionLayout = "single"
+	// PerEventTypeCondition reports the events of each EventType in its own condition, e.g.
+	// RebootScheduled, see EventTypeCondition.
+	PerEventTypeCondition ConditionLayout = "per-event-type"
+	// BothConditions reports events in both the single and per EventType layouts.
+	BothConditions ConditionLayout = "both"
+)
+
+// ParseConditionLayout parses the name of a ConditionLayout.
+func ParseConditionLayout(value string) (ConditionLayout, error) {
+	switch layout := ConditionLayout(value); layout {
+	case SingleCondition, PerEventTypeCondition, BothConditions:
+		return layout, nil
+	}
+	return "", fmt.Errorf("unknown condition layout %q, want %s, %s or %s", value, SingleCondition, PerEventTypeCondition, BothConditions)
+}
+
+// EventTypes are the EventTypes of scheduled events in the order of how disruptive they are to the node.
+var EventTypes = []string{"Freeze", "Reboot", "Redeploy", "Preempt", "Terminate"}
+
+// severity orders EventTypes by how disruptive they are to the node. Unknown EventTypes are
+// the least severe.
+func severity(eventType string) int {
+	for n := range EventTypes {
+		if EventTypes[n] == eventType {
+			return n + 1
+		}
+	}
+	return 0
+}
+
+// history is what the Watcher remembers about events, e.g. an *ack.Tracker.
+type history interface {
+	// FirstSeen returns when the event with id was first observed.
+	FirstSeen(id string) (time.Time, bool)
+	// AckedBeforeDrained reports whether the event with id was acknowledged before the node
+	// finished draining for it.
+	AckedBeforeDrained(id string) bool
+}
+
+// convert returns the status reporting the events in se: a Kubernetes event for each of them
+// and the conditions of layout, named after conditionType, for the most urgent ones. The
+// transition of a condition is when its event was first seen, according to h.
+func convert(se *metadata.ScheduledEvents, h history, layout ConditionLayout, conditionType string, now time.Time) *Status {
+	status := Status{}
+	for n := range se.Events {
+		event := Event{
+			Type:      corev1.EventTypeWarning,
+			Timestamp: now,
+			Reason:    se.Events[n].EventType,
+			Message:   Describe(&se.Events[n]),
+		}
+		status.Events = append(status.Events, event)
+	}
+	if layout != PerEventTypeCondition {
+		status.Conditions = append(status.Conditions, scheduled(conditionType, se.Events, h, now))
+	}
+	if layout != SingleCondition {
+		for _, eventType := range EventTypes {
+			var events []metadata.Event
+			for n := range se.Events {
+				if se.Events[n].EventType == eventType {
+					events = append(events, se.Events[n])
+				}
+			}
+			status.Conditions = append(status.Conditions, scheduled(EventTypeCondition(conditionType, eventType), events, h, now))
+		}
+	}
+	return &status
+}
+
+// scheduled returns the condition of conditionType for the most urgent of events. The message
+// also says which of events were acknowledged before the node finished draining.
+func scheduled(conditionType string, events []metadata.Event, h history, now time.Time) Condition {
+	if len(events) == 0 {
+		return Condition{
+			Type:       conditionType,
+			Status:     corev1.ConditionFalse,
+			Transition: now,
+			Reason:     "None",
+			Message:    "No maintenance scheduled.",
+		}
+	}
+	urgent := MostUrgent(events)
+	transition, ok := h.FirstSeen(urgent.EventID)
+	if !ok {
+		transition = now
+	}
+	message := Describe(urgent)
+	if others := len(events) - 1; others > 0 {
+		message += fmt.Sprintf(" %d other events are scheduled.", others)
+	}
+	for n := range events {
+		if h.AckedBeforeDrained(events[n].EventID) {
+			message += fmt.Sprintf(" %s was acknowledged before the node finished draining because NotBefore was about to pass.",
+				events[n].EventID)
+		}
+	}
+	return Condition{
+		Type:       conditionType,
+		Status:     corev1.ConditionTrue,
+		Transition: transition,
+		Reason:     urgent.EventType,
+		Message:    message,
+	}
+}
+
+// MostUrgent returns the most severe of events, and of those the one with the earliest NotBefore.
+// events must not be empty.
+func MostUrgent(events []metadata.Event) *metadata.Event {
+	urgent := &events[0]
+	for n := range events[1:] {
+		event := &events[n+1]
+		switch s, u := severity(event.EventType), severity(urgent.EventType); {
+		case s > u:
+			urgent = event
+		case s == u && event.NotBefore.Before(urgent.NotBefore.Time):
+			urgent = event
+		}
+	}
+	return urgent
+}
+
+// Describe returns the Description of event prefixed with the details of the event, and its
+// expected impact if the metadata service reported it. The machine readable form of the event
+// is published in a node annotation.
+func Describe(event *metadata.Event) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s is %s", event.EventType, event.EventID, event.EventStatus)
+	if event.EventSource != "" {
+		fmt.Fprintf(&b, " by %s", event.EventSource)
+	}
+	if !event.NotBefore.IsZero() {
+		fmt.Fprintf(&b, ", NotBefore %s", event.NotBefore.UTC().Format(time.RFC3339))
+	}
+	fmt.Fprintf(&b, ": %s", event.Description)
+	if d, ok := event.Duration(); ok {
+		fmt.Fprintf(&b, " Expected to last %v.", d)
+	}
+	return b.String()
+}
+
+// ackedBeforeDrained returns the status reporting that the forced events were acknowledged before
+// the node finished draining: a Kubernetes event for each of them, and the conditions of layout
+// for se saying so until the events clear.
+func ackedBeforeDrained(se *metadata.ScheduledEvents, forced []*metadata.Event, h history, layout ConditionLayout,
+	conditionType string, now time.Time) *Status {
+	status := convert(se, h, layout, conditionType, now)
+	status.Events = nil
+	for _, event := range forced {
+		status.Events = append(status.Events, Event{
+			Type:      corev1.EventTypeWarning,
+			Timestamp: now,
+			Reason:    "AckedBeforeDrained",
+			Message:   Describe(event) + " Acknowledged before the node finished draining because NotBefore is about to pass.",
+		})
+	}
+	return status
+}
+
+func metadataAvailable(now time.Time) *Status {
+	return &Status{
+		Conditions: []Condition{
+			{
+				Type:       "ScheduledEventsUnavailable",
+				Status:     corev1.ConditionFalse,
+				Transition: now,
+				Reason:     "MetadataServiceAvailable",
+				Message:    "Scheduled events are being monitored.",
+			},
+		},
+	}
+}
+
+func metadataUnavailable(err error, now time.Time) *Status {
+	return &Status{
+		Conditions: []Condition{
+			{
+				Type:       "ScheduledEventsUnavailable",
+				Status:     corev1.ConditionTrue,
+				Transition: now,
+				Reason:     "MetadataServiceUnavailable",
+				Message:    fmt.Sprintf("Failed to get scheduled events %d times in a row: %v", maxConsecutiveFailures, err),
+			},
+		},
+	}
+}
+
+func daemonStopped(now time.Time) *Status {
+	return &Status{
+		Conditions: []Condition{
+			{
+				Type:       "ScheduledEventsUnavailable",
+				Status:     corev1.ConditionUnknown,
+				Transition: now,
+				Reason:     "DaemonStopped",
+				Message:    "nodify daemon stopped, scheduled events are not being monitored.",
+			},
+		},
+	}
+}
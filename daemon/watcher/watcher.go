@@ -0,0 +1,314 @@
+// Package watcher polls scheduled events, publishes them to the node and acknowledges them
+// according to an ack.Policy.
+package watcher
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+
+	"daemon/ack"
+	"daemon/metadata"
+	"daemon/metrics"
+)
+
+// DefaultInterval is how often the Watcher polls for scheduled events.
+const DefaultInterval = 30 * time.Second
+
+// Source provides the scheduled events of the virtual machine, e.g. a *metadata.Client.
+type Source interface {
+	Scheduled(ctx context.Context) (*metadata.ScheduledEvents, error)
+	Ack(ctx context.Context, event *metadata.Event) error
+}
+
+// Exporter reports node conditions and events, e.g. a *node.Exporter.
+type Exporter interface {
+	Export(ctx context.Context, status *Status) error
+}
+
+// Node is the Kubernetes node the Watcher coordinates with the controller through.
+type Node interface {
+	// SetScheduledEvents publishes the events the controller should prepare the node for.
+	SetScheduledEvents(ctx context.Context, events []metadata.Event) error
+	// DrainedEvents returns the EventIds the controller has finished draining the node for.
+	DrainedEvents(ctx context.Context) (map[string]bool, error)
+	// LoadState returns the State last saved, or the zero State if there is none.
+	LoadState(ctx context.Context) (State, error)
+	// SaveState persists state.
+	SaveState(ctx context.Context, state State) error
+}
+
+// State is what the Watcher remembers across restarts.
+type State struct {
+	DocumentIncarnation int                       `json:"documentIncarnation"`
+	Events              map[string]ack.EventState `json:"events,omitempty"`
+}
+
+// Transition is the outcome of a Step.
+type Transition string
+
+const (
+	// TransitionNew means a new DocumentIncarnation scheduled one or more events.
+	TransitionNew Transition = "New"
+	// TransitionUnchanged means the DocumentIncarnation did not change and events, if any, are still
+	// waiting to be acknowledged.
+	TransitionUnchanged Transition = "Unchanged"
+	// TransitionAcked means the DocumentIncarnation did not change and every event has been acknowledged.
+	TransitionAcked Transition = "Acked"
+	// TransitionCleared means a new DocumentIncarnation has no events scheduled.
+	TransitionCleared Transition = "Cleared"
+	// TransitionFailed means the Step could not complete and will be retried.
+	TransitionFailed Transition = "Failed"
+)
+
+// Watcher is the daemon's polling loop.
+type Watcher struct {
+	source   Source
+	exporter Exporter
+	node     Node
+	tracker  *ack.Tracker
+	clock    clock.Clock
+	interval time.Duration
+	layout   ConditionLayout
+	// conditionType names the conditions reporting scheduled events.
+	conditionType string
+
+	incarnation int
+	// exported is set once the conditions have been reported since the Watcher started, so a
+	// restart reports them in the current layout and type even if the document did not change.
+	exported bool
+	retry    *retrier
+	saved    []byte
+	health   health
+
+	mu sync.Mutex
+	// policy replaces the Policy of the tracker at the next Step if set.
+	policy *ack.Policy
+}
+
+// Option configures a Watcher.
+type Option func(*Watcher)
+
+// WithClock replaces the real clock, e.g. with a clock.FakeClock in tests.
+func WithClock(c clock.Clock) Option {
+	return func(w *Watcher) {
+		w.clock = c
+	}
+}
+
+// WithInterval overrides DefaultInterval.
+func WithInterval(interval time.Duration) Option {
+	return func(w *Watcher) {
+		w.interval = interval
+	}
+}
+
+// WithConditionLayout overrides the SingleCondition layout of the conditions reporting scheduled events.
+func WithConditionLayout(layout ConditionLayout) Option {
+	return func(w *Watcher) {
+		w.layout = layout
+	}
+}
+
+// WithConditionType overrides DefaultConditionType, the name of the condition reporting the most
+// urgent event and of the conditions reporting each EventType, see EventTypeCondition.
+func WithConditionType(conditionType string) Option {
+	return func(w *Watcher) {
+		w.conditionType = conditionType
+	}
+}
+
+// New returns a Watcher that acknowledges events from source according to policy.
+func New(source Source, exporter Exporter, node Node, policy ack.Policy, opts ...Option) *Watcher {
+	w := &Watcher{
+		source:        source,
+		exporter:      exporter,
+		node:          node,
+		tracker:       ack.NewTracker(policy),
+		clock:         clock.RealClock{},
+		interval:      DefaultInterval,
+		layout:        SingleCondition,
+		conditionType: DefaultConditionType,
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	w.retry = newRetrier(w.interval)
+	return w
+}
+
+// Restore picks up from the State saved on the Node, so a restarted daemon neither
+// re-acknowledges events nor changes when they were first seen. The conditions are reported
+// again at the first Step, in case their layout or type changed.
+func (w *Watcher) Restore(ctx context.Context) error {
+	state, err := w.node.LoadState(ctx)
+	if err != nil {
+		return err
+	}
+	w.health.synced()
+	log.Printf("restored daemon state: %+v\n", state)
+	w.incarnation = state.DocumentIncarnation
+	w.tracker.Restore(state.Events)
+	w.saved, _ = json.Marshal(state)
+	return nil
+}
+
+// SetPolicy replaces the ack.Policy, e.g. when the configuration changes. It is safe to call
+// while Run is running and takes effect at the next Step. Events already acknowledged stay so.
+func (w *Watcher) SetPolicy(policy ack.Policy) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.policy = &policy
+}
+
+// Shutdown reports that the daemon stopped, so the controller can tell the conditions it
+// left behind are no longer kept up to date. ctx must outlive the one Run was cancelled with.
+func (w *Watcher) Shutdown(ctx context.Context) error {
+	return w.exporter.Export(ctx, daemonStopped(w.clock.Now()))
+}
+
+// Run calls Step every interval until ctx is done. Cancelling ctx also cancels in-flight requests.
+func (w *Watcher) Run(ctx context.Context) {
+	w.health.start(w.clock.Now())
+	w.export(ctx, metadataAvailable(w.clock.Now()))
+	delay := w.interval
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.clock.After(delay):
+		}
+		_, delay = w.Step(ctx)
+	}
+}
+
+// Step polls the Source once, publishes a new DocumentIncarnation and acknowledges the events
+// that are due. It returns the resulting Transition and how long to wait before the next Step.
+func (w *Watcher) Step(ctx context.Context) (Transition, time.Duration) {
+	w.mu.Lock()
+	if w.policy != nil {
+		log.Printf("ack policy changed to %s\n", w.policy)
+		w.tracker.Policy, w.policy = *w.policy, nil
+	}
+	w.mu.Unlock()
+	events, err := w.source.Scheduled(ctx)
+	if err != nil && ctx.Err() != nil {
+		// Shutting down, the request was cancelled rather than failed.
+		return TransitionFailed, 0
+	}
+	if err != nil {
+		delay := w.retry.failure(err)
+		log.Printf("error getting scheduled events (attempt %d, retrying in %v): %v\n", w.retry.failures, delay, err)
+		if w.retry.failures == maxConsecutiveFailures {
+			w.export(ctx, metadataUnavailable(err, w.clock.Now()))
+		}
+		return TransitionFailed, delay
+	}
+	if w.retry.unavailable() {
+		w.export(ctx, metadataAvailable(w.clock.Now()))
+	}
+	w.retry.success()
+	w.health.polled(w.clock.Now())
+	metrics.ObserveScheduledEvents(events, w.clock.Now())
+
+	w.tracker.Observe(events.Events, w.clock.Now())
+	transition := TransitionUnchanged
+	changed := events.DocumentIncarnation != w.incarnation
+	if changed || !w.exported {
+		log.Printf("events: %+v\npreviousIncarnation: %d\n", events, w.incarnation)
+		// The incarnation is only recorded once it has been reported, so a failure is retried.
+		if err := w.exporter.Export(ctx, convert(events, w.tracker, w.layout, w.conditionType, w.clock.Now())); err != nil {
+			log.Printf("couldn't report scheduled events: %v\n", err)
+			return TransitionFailed, w.interval
+		}
+		if err := w.node.SetScheduledEvents(ctx, events.Events); err != nil {
+			log.Printf("couldn't publish scheduled events: %v\n", err)
+			return TransitionFailed, w.interval
+		}
+		w.health.synced()
+		w.incarnation, w.exported = events.DocumentIncarnation, true
+		if changed {
+			transition = TransitionNew
+			if len(events.Events) == 0 {
+				transition = TransitionCleared
+			}
+		}
+	}
+	if w.ackDue(ctx, events) && transition == TransitionUnchanged && len(events.Events) > 0 {
+		transition = TransitionAcked
+	}
+	w.save(ctx)
+	return transition, w.interval
+}
+
+// export reports status, logging rather than failing if it cannot.
+func (w *Watcher) export(ctx context.Context, status *Status) {
+	err := w.exporter.Export(ctx, status)
+	if err == nil {
+		w.health.synced()
+	} else if ctx.Err() == nil {
+		log.Printf("couldn't report status: %v\n", err)
+	}
+}
+
+// ackDue acknowledges the events whose ack rule is satisfied. Events whose NotBefore is
+// about to pass are acknowledged regardless, and a Kubernetes event and the conditions
+// reporting them say so. It reports whether every event has been acknowledged.
+func (w *Watcher) ackDue(ctx context.Context, events *metadata.ScheduledEvents) bool {
+	var drained map[string]bool
+	if w.tracker.WaitingOnDrain(events.Events) {
+		var err error
+		if drained, err = w.node.DrainedEvents(ctx); err != nil {
+			log.Printf("couldn't get drained events: %v\n", err)
+		}
+	}
+	var forced []*metadata.Event
+	for _, due := range w.tracker.Due(events.Events, drained, w.clock.Now()) {
+		if due.Forced {
+			log.Printf("Ack event before %s, NotBefore is %v: %+v", due.Rule, due.Event.NotBefore, *due.Event)
+		} else {
+			log.Printf("Ack event (%s): %+v", due.Rule, *due.Event)
+		}
+		err := w.source.Ack(ctx, due.Event)
+		firstSeen, _ := w.tracker.FirstSeen(due.Event.EventID)
+		metrics.ObserveAck(due.Event, firstSeen, w.clock.Now(), err)
+		if err != nil {
+			log.Printf("couldn't ack event: %v\n", err)
+			continue
+		}
+		if due.Forced && due.Rule.Action == ack.AfterDrain {
+			w.tracker.MarkAckedBeforeDrained(due.Event.EventID)
+			forced = append(forced, due.Event)
+			continue
+		}
+		w.tracker.MarkAcked(due.Event.EventID)
+	}
+	if len(forced) > 0 {
+		w.export(ctx, ackedBeforeDrained(events, forced, w.tracker, w.layout, w.conditionType, w.clock.Now()))
+	}
+	for n := range events.Events {
+		if !w.tracker.Acked(events.Events[n].EventID) {
+			return false
+		}
+	}
+	return true
+}
+
+// save persists the State unless it is unchanged since the last successful save.
+func (w *Watcher) save(ctx context.Context) {
+	state := State{DocumentIncarnation: w.incarnation, Events: w.tracker.State()}
+	value, err := json.Marshal(state)
+	if err != nil || string(value) == string(w.saved) {
+		return
+	}
+	if err := w.node.SaveState(ctx, state); err != nil {
+		log.Printf("couldn't save daemon state: %v\n", err)
+		return
+	}
+	w.health.synced()
+	w.saved = value
+}
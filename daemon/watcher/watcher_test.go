@@ -0,0 +1,493 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+
+	"daemon/ack"
+	"daemon/metadata"
+)
+
+type fakeSource struct {
+	doc  metadata.ScheduledEvents
+	err  error
+	acks []string
+}
+
+func (f *fakeSource) Scheduled(ctx context.Context) (*metadata.ScheduledEvents, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &metadata.ScheduledEvents{
+		DocumentIncarnation: f.doc.DocumentIncarnation,
+		Events:              append([]metadata.Event(nil), f.doc.Events...),
+	}, nil
+}
+
+func (f *fakeSource) Ack(ctx context.Context, event *metadata.Event) error {
+	f.acks = append(f.acks, event.EventID)
+	return nil
+}
+
+type fakeExporter struct {
+	conditions []Condition
+	events     []Event
+}
+
+func (f *fakeExporter) Export(ctx context.Context, status *Status) error {
+	f.conditions = append(f.conditions, status.Conditions...)
+	f.events = append(f.events, status.Events...)
+	return nil
+}
+
+func (f *fakeExporter) last(conditionType string) Condition {
+	for n := len(f.conditions) - 1; n >= 0; n-- {
+		if f.conditions[n].Type == conditionType {
+			return f.conditions[n]
+		}
+	}
+	return Condition{}
+}
+
+type fakeNode struct {
+	scheduled []string
+	drained   map[string]bool
+	state     State
+}
+
+func (f *fakeNode) SetScheduledEvents(ctx context.Context, events []metadata.Event) error {
+	f.scheduled = nil
+	for n := range events {
+		f.scheduled = append(f.scheduled, events[n].EventID)
+	}
+	return nil
+}
+
+func (f *fakeNode) DrainedEvents(ctx context.Context) (map[string]bool, error) {
+	return f.drained, nil
+}
+
+func (f *fakeNode) LoadState(ctx context.Context) (State, error) {
+	return f.state, nil
+}
+
+func (f *fakeNode) SaveState(ctx context.Context, state State) error {
+	f.state = state
+	return nil
+}
+
+var start = time.Date(2021, 3, 30, 13, 0, 0, 0, time.UTC)
+
+func event(id, eventType string, notBefore time.Duration) metadata.Event {
+	return metadata.Event{
+		EventID:     id,
+		EventType:   eventType,
+		NotBefore:   metadata.TimeRFC1123{Time: start.Add(notBefore)},
+		Description: eventType + " scheduled.",
+	}
+}
+
+// step is a point on a maintenance timeline. If publish is set, the scheduled events
+// document is replaced with events before the Watcher steps.
+type step struct {
+	at        time.Duration
+	publish   bool
+	events    []metadata.Event
+	drained   []string
+	err       error
+	want      Transition
+	wantAcks  []string
+	condition string
+	event     string
+}
+
+func TestTimelines(t *testing.T) {
+	tests := map[string][]step{
+		"reboot acked after drain": {
+			{at: 0, publish: true, events: []metadata.Event{event("reboot", "Reboot", 15*time.Minute)}, want: TransitionNew},
+			{at: 30 * time.Second, want: TransitionUnchanged},
+			{at: time.Minute, drained: []string{"reboot"}, want: TransitionAcked, wantAcks: []string{"reboot"}},
+			{at: 90 * time.Second, drained: []string{"reboot"}, want: TransitionAcked, wantAcks: []string{"reboot"}},
+			{at: 5 * time.Minute, publish: true, want: TransitionCleared, wantAcks: []string{"reboot"}, condition: "None"},
+		},
+		"freeze acked immediately": {
+			{at: 0, publish: true, events: []metadata.Event{event("freeze", "Freeze", 15*time.Minute)},
+				want: TransitionNew, wantAcks: []string{"freeze"}, condition: "Freeze"},
+			{at: 30 * time.Second, want: TransitionAcked, wantAcks: []string{"freeze"}},
+		},
+		"NotBefore forces ack before drain": {
+			{at: 0, publish: true, events: []metadata.Event{event("reboot", "Reboot", 5*time.Minute)}, want: TransitionNew},
+			{at: 3 * time.Minute, want: TransitionUnchanged},
+			{at: 4 * time.Minute, want: TransitionAcked, wantAcks: []string{"reboot"},
+				event: "Acknowledged before the node finished draining", condition: "reboot was acknowledged before"},
+			{at: 5 * time.Minute, publish: true, events: []metadata.Event{event("reboot", "Reboot", 5*time.Minute)},
+				want: TransitionNew, wantAcks: []string{"reboot"}, condition: "reboot was acknowledged before"},
+			{at: 10 * time.Minute, publish: true, want: TransitionCleared, wantAcks: []string{"reboot"}, condition: "None"},
+		},
+		"new incarnation neither re-acks nor skips": {
+			{at: 0, publish: true, events: []metadata.Event{event("freeze", "Freeze", 15*time.Minute)},
+				want: TransitionNew, wantAcks: []string{"freeze"}},
+			{at: 30 * time.Second, publish: true,
+				events: []metadata.Event{event("freeze", "Freeze", 15*time.Minute), event("reboot", "Reboot", 15*time.Minute)},
+				want:   TransitionNew, wantAcks: []string{"freeze"}},
+			{at: time.Minute, drained: []string{"reboot"}, want: TransitionAcked, wantAcks: []string{"freeze", "reboot"}},
+		},
+		"metadata service outage": {
+			{at: 0, publish: true, want: TransitionCleared},
+			{at: 30 * time.Second, err: errors.New("connection refused"), want: TransitionFailed},
+			{at: 31 * time.Second, err: errors.New("connection refused"), want: TransitionFailed},
+			{at: 33 * time.Second, err: errors.New("connection refused"), want: TransitionFailed},
+			{at: 37 * time.Second, err: errors.New("connection refused"), want: TransitionFailed},
+			{at: 45 * time.Second, err: errors.New("connection refused"), want: TransitionFailed,
+				condition: "Failed to get scheduled events 5 times in a row"},
+			{at: 61 * time.Second, want: TransitionUnchanged, condition: "Scheduled events are being monitored."},
+		},
+	}
+	for name, steps := range tests {
+		t.Run(name, func(t *testing.T) {
+			fc := clock.NewFakeClock(start)
+			source := &fakeSource{}
+			exporter := &fakeExporter{}
+			node := &fakeNode{}
+			w := New(source, exporter, node, ack.DefaultPolicy(), WithClock(fc))
+			for _, s := range steps {
+				fc.SetTime(start.Add(s.at))
+				if s.publish {
+					source.doc.DocumentIncarnation++
+					source.doc.Events = s.events
+				}
+				source.err = s.err
+				node.drained = map[string]bool{}
+				for _, id := range s.drained {
+					node.drained[id] = true
+				}
+
+				got, _ := w.Step(context.Background())
+				if got != s.want {
+					t.Errorf("T+%v: Step() = %s, want %s", s.at, got, s.want)
+				}
+				if !reflect.DeepEqual(source.acks, s.wantAcks) {
+					t.Errorf("T+%v: acks = %v, want %v", s.at, source.acks, s.wantAcks)
+				}
+				if s.condition != "" && !hasCondition(exporter.conditions, s.condition) {
+					t.Errorf("T+%v: conditions = %+v, want one mentioning %q", s.at, exporter.conditions, s.condition)
+				}
+				if s.event != "" && !hasEvent(exporter.events, s.event) {
+					t.Errorf("T+%v: events = %+v, want one mentioning %q", s.at, exporter.events, s.event)
+				}
+				if maintenance := countConditions(exporter.conditions, "MaintenanceScheduled"); maintenance > 1 {
+					t.Errorf("T+%v: conditions = %+v, want at most one MaintenanceScheduled", s.at, exporter.conditions)
+				}
+				exporter.conditions, exporter.events = nil, nil
+			}
+		})
+	}
+}
+
+func hasCondition(conditions []Condition, text string) bool {
+	for _, c := range conditions {
+		if c.Reason == text || strings.Contains(c.Message, text) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasEvent(events []Event, text string) bool {
+	for _, e := range events {
+		if e.Reason == text || strings.Contains(e.Message, text) {
+			return true
+		}
+	}
+	return false
+}
+
+func countConditions(conditions []Condition, conditionType string) int {
+	count := 0
+	for _, c := range conditions {
+		if c.Type == conditionType {
+			count++
+		}
+	}
+	return count
+}
+
+func TestRestore(t *testing.T) {
+	fc := clock.NewFakeClock(start.Add(time.Hour))
+	source := &fakeSource{doc: metadata.ScheduledEvents{
+		DocumentIncarnation: 7,
+		Events:              []metadata.Event{event("reboot", "Reboot", 2*time.Hour)},
+	}}
+	exporter := &fakeExporter{}
+	node := &fakeNode{state: State{
+		DocumentIncarnation: 7,
+		Events:              map[string]ack.EventState{"reboot": {FirstSeen: start, Acked: true}},
+	}}
+	w := New(source, exporter, node, ack.DefaultPolicy(), WithClock(fc))
+	if err := w.Restore(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := w.Step(context.Background()); got != TransitionAcked {
+		t.Errorf("Step() = %s, want %s", got, TransitionAcked)
+	}
+	if len(source.acks) != 0 {
+		t.Errorf("acks = %v, want none after restore", source.acks)
+	}
+	if c := exporter.last("MaintenanceScheduled"); c.Reason != "Reboot" || !c.Transition.Equal(start) {
+		t.Errorf("MaintenanceScheduled = %+v, want the restored event reported since it was first seen", c)
+	}
+	if seen := node.state.Events["reboot"].FirstSeen; !seen.Equal(start) {
+		t.Errorf("saved FirstSeen = %v, want %v", seen, start)
+	}
+	exported := len(exporter.conditions)
+	if got, _ := w.Step(context.Background()); got != TransitionAcked || len(exporter.conditions) != exported {
+		t.Errorf("Step() = %s, exported %+v, want the unchanged document not to be exported again", got, exporter.conditions[exported:])
+	}
+}
+
+func TestRestoreNewLayout(t *testing.T) {
+	fc := clock.NewFakeClock(start.Add(time.Hour))
+	source := &fakeSource{doc: metadata.ScheduledEvents{
+		DocumentIncarnation: 7,
+		Events:              []metadata.Event{event("reboot", "Reboot", 2*time.Hour)},
+	}}
+	exporter := &fakeExporter{}
+	node := &fakeNode{state: State{
+		DocumentIncarnation: 7,
+		Events:              map[string]ack.EventState{"reboot": {FirstSeen: start}},
+	}}
+	w := New(source, exporter, node, ack.DefaultPolicy(), WithClock(fc),
+		WithConditionLayout(PerEventTypeCondition), WithConditionType("MaintenancePlanned"))
+	if err := w.Restore(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := w.Step(context.Background()); got != TransitionUnchanged {
+		t.Errorf("Step() = %s, want %s", got, TransitionUnchanged)
+	}
+	if c := exporter.last("RebootPlanned"); c.Status != corev1.ConditionTrue || !c.Transition.Equal(start) {
+		t.Errorf("RebootPlanned = %+v, want the restored event reported in the new layout", c)
+	}
+	if c := exporter.last("FreezePlanned"); c.Status != corev1.ConditionFalse {
+		t.Errorf("FreezePlanned = %+v, want it reported", c)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	c := exportedCondition(t, convert(&metadata.ScheduledEvents{}, seenAt{}, SingleCondition, DefaultConditionType, start))
+	if c.Type != "MaintenanceScheduled" || c.Status != corev1.ConditionFalse || c.Reason != "None" {
+		t.Errorf("convert(no events) = %+v, want MaintenanceScheduled False/None", c)
+	}
+	freeze := event("freeze", "Freeze", time.Minute)
+	freeze.DurationInSeconds = 30
+	freeze.EventStatus = "Scheduled"
+	seen := seenAt{at: start.Add(-time.Minute)}
+	c = exportedCondition(t, convert(&metadata.ScheduledEvents{Events: []metadata.Event{freeze}}, seen, SingleCondition, DefaultConditionType, start))
+	if c.Reason != "Freeze" || !c.Transition.Equal(start.Add(-time.Minute)) {
+		t.Errorf("convert(freeze) = %+v, want Freeze since it was first seen", c)
+	}
+	for _, want := range []string{"freeze is Scheduled", "NotBefore 2021-03-30T13:01:00Z", "Expected to last 30s."} {
+		if !strings.Contains(c.Message, want) {
+			t.Errorf("convert(freeze).Message = %q, want it to contain %q", c.Message, want)
+		}
+	}
+	if strings.Contains(c.Message, "acknowledged before") {
+		t.Errorf("convert(freeze).Message = %q, want no forced ack", c.Message)
+	}
+	seen.beforeDrained = "freeze"
+	c = exportedCondition(t, convert(&metadata.ScheduledEvents{Events: []metadata.Event{freeze}}, seen, SingleCondition, DefaultConditionType, start))
+	if !strings.Contains(c.Message, "freeze was acknowledged before the node finished draining") {
+		t.Errorf("convert(forced freeze).Message = %q, want it to say freeze was acknowledged before draining", c.Message)
+	}
+	c = exportedCondition(t, metadataUnavailable(errors.New("boom"), start))
+	if c.Type != "ScheduledEventsUnavailable" || c.Status != corev1.ConditionTrue || !c.Transition.Equal(start) {
+		t.Errorf("metadataUnavailable() = %+v, want ScheduledEventsUnavailable True at %v", c, start)
+	}
+}
+
+// seenAt is a history in which every event was first seen at, if set.
+type seenAt struct {
+	at            time.Time
+	beforeDrained string
+}
+
+func (s seenAt) FirstSeen(string) (time.Time, bool) {
+	return s.at, !s.at.IsZero()
+}
+
+func (s seenAt) AckedBeforeDrained(id string) bool {
+	return id == s.beforeDrained
+}
+
+func exportedCondition(t *testing.T, status *Status) Condition {
+	t.Helper()
+	if len(status.Conditions) != 1 {
+		t.Fatalf("Conditions = %+v, want exactly one", status.Conditions)
+	}
+	return status.Conditions[0]
+}
+
+func TestRunStopsOnCancel(t *testing.T) {
+	fc := clock.NewFakeClock(start)
+	source := &fakeSource{}
+	exporter := &fakeExporter{}
+	w := New(source, exporter, &fakeNode{}, ack.DefaultPolicy(), WithClock(fc))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not return after ctx was cancelled")
+	}
+
+	if err := w.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	c := exporter.last("ScheduledEventsUnavailable")
+	if c.Status != corev1.ConditionUnknown || c.Reason != "DaemonStopped" {
+		t.Errorf("condition after Shutdown() = %+v, want Unknown/DaemonStopped", c)
+	}
+}
+
+func TestStepCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	source := &fakeSource{err: context.Canceled}
+	w := New(source, &fakeExporter{}, &fakeNode{}, ack.DefaultPolicy(), WithClock(clock.NewFakeClock(start)))
+	for n := 0; n < maxConsecutiveFailures; n++ {
+		if got, _ := w.Step(ctx); got != TransitionFailed {
+			t.Errorf("Step() = %s, want %s", got, TransitionFailed)
+		}
+	}
+	if w.retry.failures != 0 {
+		t.Errorf("failures = %d, want cancelled requests not to count", w.retry.failures)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	fc := clock.NewFakeClock(start)
+	source := &fakeSource{}
+	w := New(source, &fakeExporter{}, &fakeNode{}, ack.DefaultPolicy(), WithClock(fc))
+	if err := w.Healthz(nil); err != nil {
+		t.Errorf("Healthz() = %v while starting, want nil", err)
+	}
+	if err := w.Readyz(nil); err == nil {
+		t.Error("Readyz() = nil before the first poll, want error")
+	}
+
+	w.health.start(fc.Now())
+	source.doc.DocumentIncarnation = 1
+	w.Step(context.Background())
+	if err := w.Readyz(nil); err != nil {
+		t.Errorf("Readyz() = %v after publishing to the node, want nil", err)
+	}
+
+	source.err = errors.New("connection refused")
+	fc.Step(staleIntervals * DefaultInterval)
+	w.Step(context.Background())
+	if err := w.Healthz(nil); err != nil {
+		t.Errorf("Healthz() = %v %d intervals after the last poll, want nil", err, staleIntervals)
+	}
+	fc.Step(time.Second)
+	if err := w.Healthz(nil); err == nil {
+		t.Errorf("Healthz() = nil more than %d intervals after the last poll, want error", staleIntervals)
+	}
+}
+
+func TestMostUrgent(t *testing.T) {
+	tests := []struct {
+		events []metadata.Event
+		want   string
+	}{
+		{[]metadata.Event{event("freeze", "Freeze", time.Minute), event("reboot", "Reboot", time.Hour)}, "reboot"},
+		{[]metadata.Event{event("redeploy", "Redeploy", time.Hour), event("preempt", "Preempt", time.Hour)}, "preempt"},
+		{[]metadata.Event{event("terminate", "Terminate", time.Hour), event("preempt", "Preempt", time.Minute)}, "terminate"},
+		{[]metadata.Event{event("later", "Reboot", time.Hour), event("sooner", "Reboot", time.Minute)}, "sooner"},
+	}
+	for _, tt := range tests {
+		if got := MostUrgent(tt.events).EventID; got != tt.want {
+			t.Errorf("MostUrgent(%s, %s) = %s, want %s", tt.events[0].EventID, tt.events[1].EventID, got, tt.want)
+		}
+	}
+}
+
+func TestConditionLayout(t *testing.T) {
+	se := &metadata.ScheduledEvents{Events: []metadata.Event{
+		event("freeze", "Freeze", 5*time.Minute),
+		event("reboot", "Reboot", 15*time.Minute),
+	}}
+	seen := seenAt{at: start}
+	tests := map[ConditionLayout]map[string]string{
+		SingleCondition: {"MaintenanceScheduled": "Reboot"},
+		PerEventTypeCondition: {
+			"FreezeScheduled": "Freeze", "RebootScheduled": "Reboot",
+			"RedeployScheduled": "None", "PreemptScheduled": "None", "TerminateScheduled": "None",
+		},
+		BothConditions: {"MaintenanceScheduled": "Reboot", "FreezeScheduled": "Freeze", "TerminateScheduled": "None"},
+	}
+	for layout, want := range tests {
+		conditions := map[string]Condition{}
+		for _, c := range convert(se, seen, layout, DefaultConditionType, start).Conditions {
+			conditions[c.Type] = c
+		}
+		for conditionType, reason := range want {
+			c, ok := conditions[conditionType]
+			if !ok || c.Reason != reason || (c.Status == corev1.ConditionTrue) != (reason != "None") {
+				t.Errorf("%s: %s = %+v, want reason %s", layout, conditionType, c, reason)
+			}
+		}
+		if layout == PerEventTypeCondition && len(conditions) != len(want) {
+			t.Errorf("%s: conditions = %+v, want only per EventType conditions", layout, conditions)
+		}
+	}
+	if _, err := ParseConditionLayout("split"); err == nil {
+		t.Error("ParseConditionLayout(split) succeeded, want error")
+	}
+
+	conditions := map[string]bool{}
+	for _, c := range convert(se, seen, BothConditions, "MaintenancePlanned", start).Conditions {
+		conditions[c.Type] = true
+	}
+	if !conditions["MaintenancePlanned"] || !conditions["RebootPlanned"] || conditions["MaintenanceScheduled"] {
+		t.Errorf("conditions = %v, want MaintenancePlanned and RebootPlanned", conditions)
+	}
+	for conditionType, valid := range map[string]bool{"MaintenancePlanned": true, "": false, "Ready": false, "Maintenance/Scheduled": false} {
+		if err := ValidateConditionType(conditionType); (err == nil) != valid {
+			t.Errorf("ValidateConditionType(%q) = %v, want valid %v", conditionType, err, valid)
+		}
+	}
+}
+
+func TestSetPolicy(t *testing.T) {
+	fc := clock.NewFakeClock(start)
+	source := &fakeSource{doc: metadata.ScheduledEvents{
+		DocumentIncarnation: 1,
+		Events:              []metadata.Event{event("reboot", "Reboot", time.Hour)},
+	}}
+	w := New(source, &fakeExporter{}, &fakeNode{}, ack.DefaultPolicy(), WithClock(fc))
+	if got, _ := w.Step(context.Background()); got != TransitionNew || len(source.acks) != 0 {
+		t.Fatalf("Step() = %s with acks %v, want %s waiting on the drain", got, source.acks, TransitionNew)
+	}
+	policy, err := ack.ParsePolicy("Reboot=Immediate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetPolicy(policy)
+	if got, _ := w.Step(context.Background()); got != TransitionAcked || !reflect.DeepEqual(source.acks, []string{"reboot"}) {
+		t.Errorf("Step() = %s with acks %v, want %s by the new policy", got, source.acks, TransitionAcked)
+	}
+}
@@ -74,6 +74,7 @@ func main() {
 		Clientset: clientset,
 		Log:       ctrl.Log.WithName("controllers").WithName("NodeConditionHandler"),
 		Scheme:    mgr.GetScheme(),
+		Recorder:  mgr.GetEventRecorderFor("nodify"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "NodeConditionHandler")
 		os.Exit(1)